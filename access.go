@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	QueryAccessListStatement = `SELECT serial_number, device_id, kind, COALESCE(reason, ''), expires_at
+		FROM device_access_list
+		WHERE expires_at IS NULL OR expires_at > now()`
+
+	ImportAccessListStatement = `INSERT INTO device_access_list (serial_number, kind, reason)
+		SELECT $1, 'allow', 'Imported from whitelist.txt'
+		WHERE NOT EXISTS (SELECT 1 FROM device_access_list WHERE serial_number = $1 AND kind = 'allow')`
+
+	// accessListChannel is notified by the database whenever the access list changes.
+	accessListChannel = "device_access_list"
+)
+
+// accessListReloadInterval is how often the access list is reloaded regardless of notifications,
+// permitting expired entries to lapse and recovering from missed notifications.
+const accessListReloadInterval = 5 * time.Minute
+
+// AccessKind determines whether an access list entry permits or rejects a console.
+type AccessKind string
+
+const (
+	AccessAllow AccessKind = "allow"
+	AccessBlock AccessKind = "block"
+)
+
+var (
+	ErrDeviceBlocked        = errors.New("device is blocked")
+	ErrDeviceNotWhitelisted = errors.New("device is not whitelisted")
+)
+
+// accessEntry describes a single row of the device access list.
+type accessEntry struct {
+	Kind      AccessKind
+	Reason    string
+	ExpiresAt *time.Time
+}
+
+// accessList is an in-memory copy of the device access list.
+type accessList struct {
+	mu      sync.RWMutex
+	serials map[string][]accessEntry
+	devices map[int64][]accessEntry
+}
+
+var deviceAccess accessList
+
+// loadAccessList replaces the cached access list with the current contents of the database.
+func loadAccessList() error {
+	rows, err := pool.Query(ctx, QueryAccessListStatement)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	serials := map[string][]accessEntry{}
+	devices := map[int64][]accessEntry{}
+	for rows.Next() {
+		var serialNumber *string
+		var deviceId *int64
+		var entry accessEntry
+		err = rows.Scan(&serialNumber, &deviceId, &entry.Kind, &entry.Reason, &entry.ExpiresAt)
+		if err != nil {
+			return err
+		}
+
+		if serialNumber != nil {
+			serials[*serialNumber] = append(serials[*serialNumber], entry)
+		}
+		if deviceId != nil {
+			devices[*deviceId] = append(devices[*deviceId], entry)
+		}
+	}
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	deviceAccess.mu.Lock()
+	defer deviceAccess.mu.Unlock()
+	deviceAccess.serials = serials
+	deviceAccess.devices = devices
+	return nil
+}
+
+// watchAccessList reloads the access list whenever the database notifies us of a change,
+// or otherwise periodically. It does not return.
+func watchAccessList() {
+	for {
+		err := listenAccessList()
+		log.Printf("error watching device access list, retrying: %v\n", err)
+		time.Sleep(time.Minute)
+	}
+}
+
+// listenAccessList listens for access list changes on a dedicated connection until an error occurs.
+func listenAccessList() error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	_, err = conn.Exec(ctx, "LISTEN "+accessListChannel)
+	if err != nil {
+		return err
+	}
+
+	for {
+		waitCtx, cancel := context.WithTimeout(ctx, accessListReloadInterval)
+		_, err = conn.Conn().WaitForNotification(waitCtx)
+		cancel()
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+
+		err = loadAccessList()
+		if err != nil {
+			log.Printf("error reloading device access list: %v\n", err)
+		}
+	}
+}
+
+// checkDeviceAccess determines whether the given console may use the shop.
+// Blocked consoles are always rejected, whereas consoles must be explicitly allowed
+// only if whitelisting is enabled.
+func checkDeviceAccess(deviceId int, serialNumber string) error {
+	deviceAccess.mu.RLock()
+	defer deviceAccess.mu.RUnlock()
+
+	allowed := false
+	now := time.Now().UTC()
+	for _, entries := range [][]accessEntry{deviceAccess.serials[serialNumber], deviceAccess.devices[int64(deviceId)]} {
+		for _, entry := range entries {
+			if entry.ExpiresAt != nil && entry.ExpiresAt.Before(now) {
+				continue
+			}
+
+			switch entry.Kind {
+			case AccessBlock:
+				if entry.Reason != "" {
+					return fmt.Errorf("%w: %s", ErrDeviceBlocked, entry.Reason)
+				}
+				return ErrDeviceBlocked
+			case AccessAllow:
+				allowed = true
+			}
+		}
+	}
+
+	if whitelistEnabled && !allowed {
+		return ErrDeviceNotWhitelisted
+	}
+
+	return nil
+}
+
+// readWhitelistFile returns the serial numbers listed within a legacy whitelist.txt.
+func readWhitelistFile() ([]string, error) {
+	file, err := os.Open("whitelist.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var serialNumbers []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		serialNumber := strings.TrimSpace(scanner.Text())
+		if serialNumber != "" {
+			serialNumbers = append(serialNumbers, serialNumber)
+		}
+	}
+	return serialNumbers, scanner.Err()
+}
+
+// loadWhitelistFile replaces the cached access list with the serial numbers listed within whitelist.txt,
+// for use without a database. Unlike importWhitelistFile, the file must be present.
+func loadWhitelistFile() error {
+	serialNumbers, err := readWhitelistFile()
+	if err != nil {
+		return err
+	}
+
+	serials := map[string][]accessEntry{}
+	for _, serialNumber := range serialNumbers {
+		serials[serialNumber] = append(serials[serialNumber], accessEntry{Kind: AccessAllow})
+	}
+
+	deviceAccess.mu.Lock()
+	defer deviceAccess.mu.Unlock()
+	deviceAccess.serials = serials
+	deviceAccess.devices = map[int64][]accessEntry{}
+	return nil
+}
+
+// importWhitelistFile adds serial numbers from a legacy whitelist.txt to the access list.
+// It does nothing if the file is not present.
+func importWhitelistFile() error {
+	serialNumbers, err := readWhitelistFile()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	imported := 0
+	for _, serialNumber := range serialNumbers {
+		result, err := pool.Exec(ctx, ImportAccessListStatement, serialNumber)
+		if err != nil {
+			return err
+		}
+		imported += int(result.RowsAffected())
+	}
+
+	if imported > 0 {
+		log.Printf("Imported %d serial numbers from whitelist.txt into the device access list. The file may now be removed.\n", imported)
+	}
+	return nil
+}
@@ -3,45 +3,180 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	"io"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
 )
 
-const oscAPIUrl = "https://hbb1.oscwii.org/api/v3/contents"
+// defaultOSCAPIUrl is the Open Shop Channel API used to validate purchased titles.
+const defaultOSCAPIUrl = "https://hbb1.oscwii.org/api/v3/contents"
+
+// oscRequestTimeout is how long requests to the Open Shop Channel API may take.
+const oscRequestTimeout = 10 * time.Second
+
+// defaultTitleCacheTTL is how long titles from the Open Shop Channel API are retained before being fetched again.
+const defaultTitleCacheTTL = 15 * time.Minute
 
 type OSCApp struct {
-	Shop Shop `json:"shop"`
+	Name   string `json:"name"`
+	Author string `json:"author"`
+	Shop   Shop   `json:"shop"`
 }
 
 type Shop struct {
 	TitleId string `json:"title_id"`
 	Version int    `json:"title_version"`
+	// ReferenceId is not sent by the OSC API, but may be given within local files for service titles.
+	ReferenceId string `json:"reference_id"`
 }
 
-func GetOSCApp(titleId string) (*OSCApp, error) {
-	resp, err := http.Get(oscAPIUrl)
+// fetchOSCApps returns all apps listed by the Open Shop Channel API at the given URL.
+func fetchOSCApps(client *http.Client, url string) ([]OSCApp, error) {
+	resp, err := client.Get(url)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.New("osc API returned non OK status code")
 	}
 
-	data, _ := io.ReadAll(resp.Body)
-	defer resp.Body.Close()
-
 	var apps []OSCApp
-	err = json.Unmarshal(data, &apps)
+	err = json.NewDecoder(resp.Body).Decode(&apps)
+	return apps, err
+}
+
+// readOSCApps returns all apps listed within a local file, in the format of the Open Shop Channel API.
+func readOSCApps(path string) ([]OSCApp, error) {
+	contents, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
+	var apps []OSCApp
+	err = json.Unmarshal(contents, &apps)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return apps, nil
+}
+
+// loadOSCApps returns all apps from the given source, either an HTTP(S) URL or the path of a local file.
+func loadOSCApps(source string) ([]OSCApp, error) {
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		return fetchOSCApps(&http.Client{Timeout: oscRequestTimeout}, source)
+	}
+	return readOSCApps(source)
+}
+
+// oscTitles returns the titles within the given list of Open Shop Channel apps, keyed by title ID.
+func oscTitles(apps []OSCApp) map[string]TitleMetadata {
+	titles := map[string]TitleMetadata{}
 	for _, app := range apps {
-		if app.Shop.TitleId == titleId {
-			return &app, nil
+		titles[app.Shop.TitleId] = TitleMetadata{
+			TitleId: app.Shop.TitleId,
+			Version: app.Shop.Version,
 		}
 	}
+	return titles
+}
+
+// oscTitleProvider provides titles listed by the Open Shop Channel API.
+// The list is cached for the given TTL, and kept should the API fail to respond once it has expired.
+type oscTitleProvider struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu        sync.Mutex
+	titles    map[string]TitleMetadata
+	fetchedAt time.Time
+	// fetchErr is the error encountered by the most recent fetch, if any.
+	fetchErr error
+	// refreshing is closed once the refresh in progress completes, or nil if there is none.
+	refreshing chan struct{}
+}
+
+// newOSCTitleProvider returns a TitleProvider for the Open Shop Channel API at the given URL.
+func newOSCTitleProvider(url string, ttl time.Duration) *oscTitleProvider {
+	return &oscTitleProvider{
+		url: url,
+		ttl: ttl,
+		client: &http.Client{
+			Timeout: oscRequestTimeout,
+		},
+	}
+}
+
+// fetch returns all titles currently listed by the Open Shop Channel API.
+func (p *oscTitleProvider) fetch() (map[string]TitleMetadata, error) {
+	apps, err := fetchOSCApps(p.client, p.url)
+	if err != nil {
+		return nil, err
+	}
+
+	return oscTitles(apps), nil
+}
+
+func (p *oscTitleProvider) Title(titleId string) (*TitleMetadata, error) {
+	p.mu.Lock()
+	done := p.refreshing
+	if done == nil && (p.titles == nil || time.Since(p.fetchedAt) >= p.ttl) {
+		done = make(chan struct{})
+		p.refreshing = done
+		go p.refresh(done)
+	}
+	titles := p.titles
+	p.mu.Unlock()
+
+	// Cached titles continue to be served while refreshing. Without any, we must wait.
+	if titles == nil && done != nil {
+		<-done
+
+		p.mu.Lock()
+		titles = p.titles
+		err := p.fetchErr
+		p.mu.Unlock()
+		if titles == nil {
+			return nil, err
+		}
+	}
+
+	title, ok := titles[titleId]
+	if !ok {
+		return nil, nil
+	}
+	return &title, nil
+}
+
+// refresh replaces our cached titles with those currently listed, closing done once complete.
+// The API is requested without holding our lock, so that other requests are not held up.
+func (p *oscTitleProvider) refresh(done chan struct{}) {
+	titles, err := p.fetch()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	defer close(done)
+
+	if err != nil && p.titles != nil {
+		// Stale titles are preferable to failing purchases while the API is unavailable.
+		log.Printf("error refreshing titles from the OSC API, retaining %d cached titles: %v", len(p.titles), err)
+	} else if err == nil {
+		p.titles = titles
+	}
+
+	// Either way, we wait until the TTL elapses again before retrying.
+	p.fetchErr = err
+	p.fetchedAt = time.Now()
+	p.refreshing = nil
+}
 
-	return nil, nil
+// String describes this provider for logging.
+func (p *oscTitleProvider) String() string {
+	return fmt.Sprintf("the OSC API at %s, cached for %s", p.url, p.ttl)
 }
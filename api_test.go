@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testOSCApps = `[{"shop":{"title_id":"0001000148414441","title_version":2}},{"shop":{"title_id":"0001000548414441","title_version":0}}]`
+
+func TestOSCTitleProviderCaches(t *testing.T) {
+	previousOutput := log.Writer()
+	defer log.SetOutput(previousOutput)
+	log.SetOutput(io.Discard)
+
+	var requests int32
+	var failing int32
+	osc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, testOSCApps)
+	}))
+	defer osc.Close()
+
+	provider := newOSCTitleProvider(osc.URL, time.Hour)
+	for _, titleId := range []string{"0001000148414441", "0001000548414441", "0001000148414441"} {
+		title, err := provider.Title(titleId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if title == nil || title.TitleId != titleId {
+			t.Errorf("Title(%q) = %v; expected it to be listed", titleId, title)
+		}
+	}
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Errorf("the API was requested %d times; expected titles to be cached", requests)
+	}
+
+	title, err := provider.Title("0001000148415858")
+	if err != nil || title != nil {
+		t.Errorf("Title of an unlisted title = %v, %v; expected nil", title, err)
+	}
+
+	// Once expired, cached titles are kept should the API fail.
+	atomic.StoreInt32(&failing, 1)
+	expireTitles(provider)
+	title, err = provider.Title("0001000148414441")
+	if err != nil || title == nil || title.Version != 2 {
+		t.Errorf("Title with a failing API = %v, %v; expected the cached title", title, err)
+	}
+	awaitRefresh(provider)
+	title, err = provider.Title("0001000148414441")
+	if err != nil || title == nil || title.Version != 2 {
+		t.Errorf("Title after a failed refresh = %v, %v; expected the cached title", title, err)
+	}
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("the API was requested %d times; expected a refresh once expired", requests)
+	}
+
+	// Without any cached titles, failures are reported.
+	_, err = newOSCTitleProvider(osc.URL, time.Hour).Title("0001000148414441")
+	if err == nil {
+		t.Error("Title with a failing API and no cache succeeded; expected an error")
+	}
+}
+
+func TestOSCTitleProviderServesWhileRefreshing(t *testing.T) {
+	release := make(chan struct{})
+	var blocking int32
+	osc := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&blocking) == 1 {
+			<-release
+		}
+		fmt.Fprint(w, testOSCApps)
+	}))
+	defer osc.Close()
+	defer close(release)
+
+	provider := newOSCTitleProvider(osc.URL, time.Hour)
+	if _, err := provider.Title("0001000148414441"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Refreshing must not hold up requests for titles already cached.
+	atomic.StoreInt32(&blocking, 1)
+	expireTitles(provider)
+	served := make(chan *TitleMetadata)
+	go func() {
+		for i := 0; i < 2; i++ {
+			title, _ := provider.Title("0001000148414441")
+			served <- title
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case title := <-served:
+			if title == nil {
+				t.Error("Title while refreshing = nil; expected the cached title")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Title blocked while refreshing; expected the cached title")
+		}
+	}
+}
+
+// expireTitles marks the titles cached by the given provider as expired.
+func expireTitles(p *oscTitleProvider) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fetchedAt = time.Now().Add(-2 * p.ttl)
+}
+
+// awaitRefresh waits for any refresh in progress by the given provider to complete.
+func awaitRefresh(p *oscTitleProvider) {
+	p.mu.Lock()
+	done := p.refreshing
+	p.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+func TestFileTitleProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contents.json")
+	if err := os.WriteFile(path, []byte(testOSCApps), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := newTitleProvider(TitleProviderFile, path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	title, err := provider.Title("0001000548414441")
+	if err != nil || title == nil || title.Version != 0 {
+		t.Errorf("Title of a listed title = %v, %v; expected it to be listed", title, err)
+	}
+	title, err = provider.Title("0001000148415858")
+	if err != nil || title != nil {
+		t.Errorf("Title of an unlisted title = %v, %v; expected nil", title, err)
+	}
+}
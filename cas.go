@@ -1,79 +1,159 @@
 package main
 
-import "log"
+import (
+	"log"
+	"strconv"
+)
 
-const QueryTitlesTableByPriceCode = `SELECT item_id, price FROM service_titles WHERE price_code = $1`
+// ListItemsRequest describes the ListItems action.
+type ListItemsRequest struct {
+	// Items may be listed across all titles.
+	TitleId string `xml:"TitleId"`
+	// Filters are optional, and all items are listed in their absence.
+	AttributeFilters []NameValue `xml:"AttributeFilters"`
+	listRange
+}
+
+func listItems(e *Envelope, request *ListItemsRequest) error {
+	filters := nameValues(request.AttributeFilters)
 
-func listItems(e *Envelope) {
-	titleId, err := e.getKey("TitleId")
+	filter := catalogFilter{
+		TitleId: request.TitleId,
+	}
+	if value, ok := filters["TitleKind"]; ok {
+		licenceKind, err := GetLicenceKind(value)
+		if err != nil {
+			return actionError(ErrorCodeInvalidRequest, "Invalid TitleKind was passed by SOAP", err)
+		}
+		filter.LicenseKind = *licenceKind
+	}
+	if value, ok := filters["PricingCode"]; ok {
+		pricingCode, err := strconv.Atoi(value)
+		if err != nil {
+			return actionError(ErrorCodeInvalidRequest, "Invalid PricingCode was passed by SOAP", err)
+		}
+		filter.PricingCode = pricingCode
+	}
+
+	var err error
+	filter.Offset, filter.Size, err = request.Range()
 	if err != nil {
-		e.Error(9, "Unable to obtain title.", err)
+		return actionError(ErrorCodeInvalidRequest, "Invalid list range was passed by SOAP", err)
 	}
 
-	attrs, err := e.getKeys("AttributeFilters")
+	items, total, err := store.CatalogItems(filter)
 	if err != nil {
-		e.Error(5, "AttributeFilters key did not exist!", err)
+		log.Printf("error while querying catalog: %v", err)
+		return actionError(ErrorCodeServerError, "error retrieving title", nil)
 	}
 
-	var licenceStr string
-	var pricingCode string
-	for _, attr := range attrs {
-		name, value := parseNameValue(attr.InnerText())
-		if name == "TitleKind" {
-			licenceStr = value
-		} else if name == "PricingCode" {
-			pricingCode = value
+	e.AddKVNode("ListResultTotalSize", strconv.Itoa(total))
+	for _, item := range items {
+		// Items granting the whole title include it outright, whereas
+		// items granting a content set list each of its contents.
+		contents := []ContentsMetadata{{TitleIncluded: true}}
+		if item.ContentIndexes != nil {
+			contents = nil
+			for _, contentIndex := range item.ContentIndexes {
+				contentIndex := contentIndex
+				contents = append(contents, ContentsMetadata{TitleIncluded: false, ContentIndex: &contentIndex})
+			}
 		}
+
+		e.AddCustomType(Items{
+			TitleId:  item.TitleId,
+			Contents: contents,
+			Attributes: []Attributes{
+				{
+					Name:  "TitleVersion",
+					Value: strconv.Itoa(item.TitleVersion),
+				},
+				{
+					Name:  "Prices",
+					Value: strconv.Itoa(len(item.Prices)),
+				},
+			},
+			Ratings: item.Ratings,
+			Prices:  item.Prices,
+		})
 	}
 
-	// Now validate
-	licenceKind, err := GetLicenceKind(licenceStr)
+	return nil
+}
+
+// ListTitlesRequest describes the ListTitles action.
+type ListTitlesRequest struct {
+	AttributeFilters []NameValue `xml:"AttributeFilters"`
+	listRange
+}
+
+func listTitles(e *Envelope, request *ListTitlesRequest) error {
+	filters := nameValues(request.AttributeFilters)
+
+	offset, size, err := request.Range()
+	if err != nil {
+		return actionError(ErrorCodeInvalidRequest, "Invalid list range was passed by SOAP", err)
+	}
+
+	titles, total, err := store.CatalogTitles(filters["Category"], filters["Platform"], offset, size)
 	if err != nil {
-		e.Error(5, "Invalid TitleKind was passed by SOAP", err)
+		log.Printf("error while querying catalog titles: %v", err)
+		return actionError(ErrorCodeServerError, "error retrieving titles", nil)
 	}
 
-	// Query the titles table to get our title
-	row := pool.QueryRow(ctx, QueryTitlesTableByPriceCode, pricingCode)
+	e.AddKVNode("ListResultTotalSize", strconv.Itoa(total))
+	for _, title := range titles {
+		e.AddCustomType(title)
+	}
+
+	return nil
+}
 
-	var itemId int
-	var price int
-	err = row.Scan(&itemId, &price)
+// TitleRequest describes actions concerning a single title, such as GetTitleDetails.
+type TitleRequest struct {
+	TitleId string `xml:"TitleId" soap:"required"`
+}
+
+func getTitleDetails(e *Envelope, request *TitleRequest) error {
+	title, err := store.CatalogTitle(request.TitleId)
 	if err != nil {
-		log.Printf("error while querying titles table: %v", err)
-		e.Error(2, "error retrieving title", nil)
-		return
+		log.Printf("error while querying catalog title: %v", err)
+		return actionError(ErrorCodeServerError, "error retrieving title", nil)
+	} else if title == nil {
+		return actionError(ErrorCodeTitleNotFound, "title does not exist", nil)
 	}
 
-	e.AddKVNode("ListResultTotalSize", "1")
-	e.AddCustomType(Items{
-		TitleId: titleId,
-		Contents: ContentsMetadata{
-			TitleIncluded: false,
-			ContentIndex:  0,
-		},
-		Attributes: []Attributes{
-			{
-				Name:  "TitleVersion",
-				Value: "0",
-			},
-			{
-				Name:  "Prices",
-				Value: "1",
-			},
-		},
-		Ratings: Ratings{
-			Name:   "E",
-			Rating: 1,
-			Age:    9,
-		},
-		Prices: Prices{
-			ItemId: itemId,
-			Price: Price{
-				Amount:   price,
-				Currency: "POINTS",
-			},
-			Limits:      LimitStruct(PR),
-			LicenseKind: *licenceKind,
-		},
-	})
+	e.AddCustomType(title)
+
+	return nil
+}
+
+func listContentSets(e *Envelope, request *TitleRequest) error {
+	sets, err := store.ContentSets(request.TitleId)
+	if err != nil {
+		log.Printf("error while querying content sets: %v", err)
+		return actionError(ErrorCodeServerError, "error retrieving content sets", nil)
+	}
+
+	e.AddKVNode("ListResultTotalSize", strconv.Itoa(len(sets)))
+	for _, set := range sets {
+		e.AddCustomType(set)
+	}
+
+	return nil
+}
+
+func listCategories(e *Envelope) error {
+	categories, err := store.Categories()
+	if err != nil {
+		log.Printf("error while querying categories: %v", err)
+		return actionError(ErrorCodeServerError, "error retrieving categories", nil)
+	}
+
+	e.AddKVNode("ListResultTotalSize", strconv.Itoa(len(categories)))
+	for _, category := range categories {
+		e.AddCustomType(category)
+	}
+
+	return nil
 }
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	QueryCatalogItemsStatement = `SELECT service_titles.item_id, service_titles.title_id,
+			COALESCE((SELECT MAX(catalog_title_versions.version) FROM catalog_title_versions
+				WHERE catalog_title_versions.title_id = service_titles.title_id), 0)
+		FROM service_titles
+		WHERE ($1 = '' OR service_titles.title_id = $1)
+		AND EXISTS (` + catalogPriceFilter + `)
+		ORDER BY service_titles.item_id
+		OFFSET $4 LIMIT $5`
+
+	CountCatalogItemsStatement = `SELECT COUNT(*)
+		FROM service_titles
+		WHERE ($1 = '' OR service_titles.title_id = $1)
+		AND EXISTS (` + catalogPriceFilter + `)`
+
+	// catalogPriceFilter matches prices for an item against the given licence kind ($2) and pricing code ($3).
+	catalogPriceFilter = `SELECT 1 FROM catalog_prices
+		WHERE catalog_prices.item_id = service_titles.item_id
+		AND ($2 = '' OR catalog_prices.license_kind = $2)
+		AND ($3 = 0 OR catalog_prices.price_code = $3)`
+
+	QueryCatalogPricesStatement = `SELECT item_id, price_code, amount, currency, license_kind, limit_kind, limit_value
+		FROM catalog_prices
+		WHERE item_id = ANY($1)
+		AND ($2 = '' OR license_kind = $2)
+		AND ($3 = 0 OR price_code = $3)
+		ORDER BY item_id, price_id`
+
+	QueryCatalogItemStatement = `SELECT service_titles.title_id,
+			COALESCE((SELECT MAX(catalog_title_versions.version) FROM catalog_title_versions
+				WHERE catalog_title_versions.title_id = service_titles.title_id), 0),
+			COALESCE(service_titles.reference_id, '')
+		FROM service_titles
+		WHERE service_titles.item_id = $1`
+
+	QueryItemPricesStatement = `SELECT amount, currency, license_kind, limit_kind, limit_value
+		FROM catalog_prices
+		WHERE item_id = $1
+		ORDER BY price_id`
+
+	QueryCatalogRatingsStatement = `SELECT title_id, name, rating, age
+		FROM catalog_ratings
+		WHERE title_id = ANY($1)
+		ORDER BY title_id, name`
+
+	QueryCatalogDescriptorsStatement = `SELECT title_id, name, descriptor
+		FROM catalog_rating_descriptors
+		WHERE title_id = ANY($1)
+		ORDER BY title_id, name, descriptor`
+
+	QueryCatalogTitlesStatement = `SELECT catalog_titles.title_id, catalog_titles.name, catalog_titles.platform,
+			COALESCE(catalog_titles.publisher, ''),
+			COALESCE((SELECT MAX(catalog_title_versions.version) FROM catalog_title_versions
+				WHERE catalog_title_versions.title_id = catalog_titles.title_id), 0)
+		FROM catalog_titles
+		WHERE ` + catalogTitleFilter + `
+		ORDER BY catalog_titles.title_id
+		OFFSET $3 LIMIT $4`
+
+	CountCatalogTitlesStatement = `SELECT COUNT(*)
+		FROM catalog_titles
+		WHERE ` + catalogTitleFilter
+
+	// catalogTitleFilter matches titles against the given category code ($1) and platform ($2).
+	catalogTitleFilter = `($1 = '' OR EXISTS (SELECT 1 FROM catalog_title_categories
+			WHERE catalog_title_categories.title_id = catalog_titles.title_id
+			AND catalog_title_categories.category_code = $1))
+		AND ($2 = '' OR catalog_titles.platform = $2)`
+
+	QueryCatalogTitleStatement = `SELECT catalog_titles.title_id, catalog_titles.name, catalog_titles.platform,
+			COALESCE(catalog_titles.publisher, ''),
+			COALESCE(catalog_title_versions.version, 0), COALESCE(catalog_title_versions.title_size, 0)
+		FROM catalog_titles
+		LEFT JOIN catalog_title_versions ON catalog_title_versions.title_id = catalog_titles.title_id
+		WHERE catalog_titles.title_id = $1
+		ORDER BY catalog_title_versions.version DESC NULLS LAST
+		LIMIT 1`
+
+	QueryTitleCategoriesStatement = `SELECT catalog_categories.category_code, catalog_categories.name
+		FROM catalog_categories, catalog_title_categories
+		WHERE catalog_categories.category_code = catalog_title_categories.category_code
+		AND catalog_title_categories.title_id = $1
+		ORDER BY catalog_categories.category_code`
+
+	QueryCategoriesStatement = `SELECT category_code, name
+		FROM catalog_categories
+		ORDER BY category_code`
+
+	QueryTitleContentsStatement = `SELECT content_index, content_id, content_size
+		FROM catalog_contents
+		WHERE title_id = $1
+		ORDER BY content_index`
+
+	QueryContentSetsStatement = `SELECT catalog_content_sets.content_set_id, catalog_content_sets.name,
+			catalog_content_set_contents.content_index
+		FROM catalog_content_sets
+		LEFT JOIN catalog_content_set_contents
+			ON catalog_content_set_contents.content_set_id = catalog_content_sets.content_set_id
+		WHERE catalog_content_sets.title_id = $1
+		ORDER BY catalog_content_sets.content_set_id, catalog_content_set_contents.content_index`
+)
+
+// catalogFilter describes the criteria items are listed by.
+// Zero values match all items.
+type catalogFilter struct {
+	TitleId     string
+	LicenseKind LicenceKinds
+	PricingCode int
+	Offset      int
+	Size        int
+}
+
+// catalogItem describes an item available for purchase, alongside the title it grants.
+type catalogItem struct {
+	ItemId       int
+	TitleId      string
+	TitleVersion int
+	// ReferenceId identifies the purchase of items for service titles, such as Wii no Ma's theatres.
+	ReferenceId string
+	// ContentIndexes lists the contents this item grants, or nil if it grants its title as a whole.
+	ContentIndexes []int
+	Ratings        []Ratings
+	Prices         []Prices
+}
+
+// queryCatalogItems returns a page of items matching the given filter,
+// alongside the total amount of items matching.
+func queryCatalogItems(q querier, filter catalogFilter) ([]catalogItem, int, error) {
+	var total int
+	err := q.QueryRow(ctx, CountCatalogItemsStatement, filter.TitleId, string(filter.LicenseKind), filter.PricingCode).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := q.Query(ctx, QueryCatalogItemsStatement, filter.TitleId, string(filter.LicenseKind), filter.PricingCode, filter.Offset, filter.Size)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var items []catalogItem
+	itemIndex := map[int]int{}
+	var itemIds []int
+	var titleIds []string
+	for rows.Next() {
+		var item catalogItem
+		err = rows.Scan(&item.ItemId, &item.TitleId, &item.TitleVersion)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		itemIndex[item.ItemId] = len(items)
+		itemIds = append(itemIds, item.ItemId)
+		titleIds = append(titleIds, item.TitleId)
+		items = append(items, item)
+	}
+	if rows.Err() != nil {
+		return nil, 0, rows.Err()
+	}
+
+	// Populate prices for every item listed.
+	priceRows, err := q.Query(ctx, QueryCatalogPricesStatement, itemIds, string(filter.LicenseKind), filter.PricingCode)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer priceRows.Close()
+
+	for priceRows.Next() {
+		var itemId, priceCode int
+		var price Prices
+		err = priceRows.Scan(&itemId, &priceCode, &price.Price.Amount, &price.Price.Currency, &price.LicenseKind,
+			&price.Limits.LimitKind, &price.Limits.Limits)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		price.ItemId = itemId
+		item := &items[itemIndex[itemId]]
+		item.Prices = append(item.Prices, price)
+	}
+	if priceRows.Err() != nil {
+		return nil, 0, priceRows.Err()
+	}
+
+	ratings, err := queryCatalogRatings(q, titleIds)
+	if err != nil {
+		return nil, 0, err
+	}
+	contents, err := queryItemContents(q, itemIds)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range items {
+		items[i].Ratings = ratings[items[i].TitleId]
+		items[i].ContentIndexes = contents[items[i].ItemId]
+	}
+
+	return items, total, nil
+}
+
+// queryCatalogItem returns the given item alongside all of its prices, or nil if it is not within our catalog.
+func queryCatalogItem(q querier, itemId int) (*catalogItem, error) {
+	item := catalogItem{ItemId: itemId}
+	err := q.QueryRow(ctx, QueryCatalogItemStatement, itemId).Scan(&item.TitleId, &item.TitleVersion, &item.ReferenceId)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	item.Prices, err = queryItemPrices(q, itemId)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := queryItemContents(q, []int{itemId})
+	if err != nil {
+		return nil, err
+	}
+	item.ContentIndexes = contents[itemId]
+
+	return &item, nil
+}
+
+// queryItemPrices returns all prices listed for the given item.
+func queryItemPrices(q querier, itemId int) ([]Prices, error) {
+	rows, err := q.Query(ctx, QueryItemPricesStatement, itemId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prices []Prices
+	for rows.Next() {
+		price := Prices{ItemId: itemId}
+		err = rows.Scan(&price.Price.Amount, &price.Price.Currency, &price.LicenseKind,
+			&price.Limits.LimitKind, &price.Limits.Limits)
+		if err != nil {
+			return nil, err
+		}
+
+		prices = append(prices, price)
+	}
+
+	return prices, rows.Err()
+}
+
+// queryCatalogRatings returns the ratings, with descriptors, for each of the given titles.
+func queryCatalogRatings(q querier, titleIds []string) (map[string][]Ratings, error) {
+	rows, err := q.Query(ctx, QueryCatalogRatingsStatement, titleIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ratings := map[string][]Ratings{}
+	for rows.Next() {
+		var titleId string
+		var rating Ratings
+		err = rows.Scan(&titleId, &rating.Name, &rating.Rating, &rating.Age)
+		if err != nil {
+			return nil, err
+		}
+
+		ratings[titleId] = append(ratings[titleId], rating)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+
+	descriptorRows, err := q.Query(ctx, QueryCatalogDescriptorsStatement, titleIds)
+	if err != nil {
+		return nil, err
+	}
+	defer descriptorRows.Close()
+
+	for descriptorRows.Next() {
+		var titleId, name, descriptor string
+		err = descriptorRows.Scan(&titleId, &name, &descriptor)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, rating := range ratings[titleId] {
+			if rating.Name == name {
+				ratings[titleId][i].Descriptors = append(ratings[titleId][i].Descriptors, descriptor)
+			}
+		}
+	}
+
+	return ratings, descriptorRows.Err()
+}
+
+// queryCatalogTitles returns a page of titles within the given category and platform,
+// alongside the total amount of titles matching. Empty values match all titles.
+func queryCatalogTitles(q querier, category string, platform string, offset int, size int) ([]TitleInfo, int, error) {
+	var total int
+	err := q.QueryRow(ctx, CountCatalogTitlesStatement, category, platform).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := q.Query(ctx, QueryCatalogTitlesStatement, category, platform, offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var titles []TitleInfo
+	for rows.Next() {
+		var title TitleInfo
+		err = rows.Scan(&title.TitleId, &title.TitleName, &title.Platform, &title.Publisher, &title.TitleVersion)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		titles = append(titles, title)
+	}
+
+	return titles, total, rows.Err()
+}
+
+// queryCatalogTitle returns details about the given title, including its ratings, categories and contents.
+// It returns nil if the title is not within our catalog.
+func queryCatalogTitle(q querier, titleId string) (*TitleInfo, error) {
+	var title TitleInfo
+	err := q.QueryRow(ctx, QueryCatalogTitleStatement, titleId).Scan(&title.TitleId, &title.TitleName,
+		&title.Platform, &title.Publisher, &title.TitleVersion, &title.TitleSize)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	ratings, err := queryCatalogRatings(q, []string{titleId})
+	if err != nil {
+		return nil, err
+	}
+	title.Ratings = ratings[titleId]
+
+	title.Categories, err = queryCategories(q, QueryTitleCategoriesStatement, titleId)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(ctx, QueryTitleContentsStatement, titleId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var content ContentInfo
+		var contentId int64
+		err = rows.Scan(&content.ContentIndex, &contentId, &content.ContentSize)
+		if err != nil {
+			return nil, err
+		}
+
+		content.ContentId = fmt.Sprintf("%08x", contentId)
+		title.Contents = append(title.Contents, content)
+	}
+
+	return &title, rows.Err()
+}
+
+// queryCategories returns all categories for the given statement and arguments.
+func queryCategories(q querier, statement string, args ...interface{}) ([]Category, error) {
+	rows, err := q.Query(ctx, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []Category
+	for rows.Next() {
+		var category Category
+		err = rows.Scan(&category.CategoryCode, &category.CategoryName)
+		if err != nil {
+			return nil, err
+		}
+
+		categories = append(categories, category)
+	}
+
+	return categories, rows.Err()
+}
+
+// queryContentSets returns all content sets available for the given title.
+func queryContentSets(q querier, titleId string) ([]ContentSet, error) {
+	rows, err := q.Query(ctx, QueryContentSetsStatement, titleId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sets []ContentSet
+	for rows.Next() {
+		var setId int
+		var name string
+		var contentIndex *int
+		err = rows.Scan(&setId, &name, &contentIndex)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(sets) == 0 || sets[len(sets)-1].ContentSetId != setId {
+			sets = append(sets, ContentSet{
+				ContentSetId: setId,
+				Name:         name,
+			})
+		}
+		if contentIndex != nil {
+			current := &sets[len(sets)-1]
+			current.ContentIndexes = append(current.ContentIndexes, *contentIndex)
+		}
+	}
+
+	return sets, rows.Err()
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	QuerySyncedItemsStatement = `SELECT item_id, title_id, COALESCE(reference_id, '')
+		FROM service_titles
+		ORDER BY item_id`
+
+	QuerySyncedTitlesStatement = `SELECT catalog_titles.title_id, catalog_titles.name,
+			COALESCE(MAX(catalog_title_versions.version), -1)
+		FROM catalog_titles
+		LEFT JOIN catalog_title_versions ON catalog_title_versions.title_id = catalog_titles.title_id
+		GROUP BY catalog_titles.title_id, catalog_titles.name`
+
+	UpdateItemReferenceStatement = `UPDATE service_titles SET reference_id = $2 WHERE item_id = $1`
+
+	InsertSyncedTitleStatement = `INSERT INTO catalog_titles (title_id, name, publisher) VALUES ($1, $2, $3)`
+
+	InsertSyncedTitleVersionStatement = `INSERT INTO catalog_title_versions (title_id, version) VALUES ($1, $2)
+		ON CONFLICT (title_id, version) DO NOTHING`
+)
+
+// catalogChangeKind describes what a catalogChange does.
+type catalogChangeKind int
+
+const (
+	// catalogAddItem creates an item for a title, such that it may be purchased.
+	catalogAddItem catalogChangeKind = iota
+	// catalogAddTitle creates a title within the catalog.
+	catalogAddTitle
+	// catalogRenameTitle updates the name of an existing title.
+	catalogRenameTitle
+	// catalogAddVersion records a newer version of a title.
+	catalogAddVersion
+	// catalogSetReference updates the reference ID of an existing item.
+	catalogSetReference
+)
+
+// catalogChange describes a single change made to the catalog by a sync.
+type catalogChange struct {
+	Kind        catalogChangeKind
+	TitleId     string
+	ItemId      int
+	ReferenceId string
+	Name        string
+	Publisher   string
+	Version     int
+}
+
+func (c catalogChange) String() string {
+	switch c.Kind {
+	case catalogAddItem:
+		if c.ReferenceId != "" {
+			return fmt.Sprintf("+ item %d for %s (reference ID %s)", c.ItemId, c.TitleId, c.ReferenceId)
+		}
+		return fmt.Sprintf("+ item %d for %s", c.ItemId, c.TitleId)
+	case catalogAddTitle:
+		return fmt.Sprintf("+ title %s named %q", c.TitleId, c.Name)
+	case catalogRenameTitle:
+		return fmt.Sprintf("~ title %s renamed to %q", c.TitleId, c.Name)
+	case catalogAddVersion:
+		return fmt.Sprintf("~ title %s at version %d", c.TitleId, c.Version)
+	case catalogSetReference:
+		return fmt.Sprintf("~ item %d for %s given reference ID %s", c.ItemId, c.TitleId, c.ReferenceId)
+	default:
+		return fmt.Sprintf("? unknown change to %s", c.TitleId)
+	}
+}
+
+// syncedItem describes an existing item within service_titles.
+type syncedItem struct {
+	ItemId      int
+	TitleId     string
+	ReferenceId string
+}
+
+// syncedTitle describes an existing title within the catalog, and the newest version known.
+// Version is -1 if no versions are known.
+type syncedTitle struct {
+	TitleId string
+	Name    string
+	Version int
+}
+
+// catalogSync describes all changes necessary to bring the catalog in line with a feed.
+type catalogSync struct {
+	Changes []catalogChange
+	// Retained lists titles with items which are not within the feed. They are never removed,
+	// as consoles which have purchased them must continue to be able to download them.
+	Retained []string
+	// Skipped lists entries within the feed which could not be imported.
+	Skipped []string
+}
+
+// planCatalogSync determines the changes necessary for the given catalog to contain all titles within the feed.
+// Nothing is ever removed from the catalog.
+//
+// Title IDs are matched regardless of case, as titles imported prior to syncing may be stored in lowercase.
+// Changes to existing titles and items retain the case they are stored in, whereas new titles are upper case.
+func planCatalogSync(items []syncedItem, titles map[string]syncedTitle, apps []OSCApp) catalogSync {
+	var plan catalogSync
+	itemsByTitle := map[string][]*syncedItem{}
+	for i := range items {
+		key := strings.ToUpper(items[i].TitleId)
+		itemsByTitle[key] = append(itemsByTitle[key], &items[i])
+	}
+	titlesById := map[string]syncedTitle{}
+	for _, title := range titles {
+		titlesById[strings.ToUpper(title.TitleId)] = title
+	}
+
+	listed := map[string]bool{}
+	for _, app := range apps {
+		key := strings.ToUpper(strings.TrimSpace(app.Shop.TitleId))
+		referenceId := strings.TrimSpace(app.Shop.ReferenceId)
+		if _, err := strconv.ParseUint(key, 16, 64); err != nil || len(key) != 16 {
+			plan.Skipped = append(plan.Skipped, fmt.Sprintf("%q has an invalid title ID", app.Name))
+			continue
+		}
+		if app.Shop.Version < 0 || app.Shop.Version > 0xFFFF {
+			plan.Skipped = append(plan.Skipped, fmt.Sprintf("%s has an invalid version %d", key, app.Shop.Version))
+			continue
+		}
+		listed[key] = true
+
+		// Items are created for the title as it is stored, or as the feed lists it if new.
+		titleId := key
+		if title, ok := titlesById[key]; ok {
+			titleId = title.TitleId
+		} else if existing := itemsByTitle[key]; len(existing) > 0 {
+			titleId = existing[0].TitleId
+		}
+
+		// Determine whether an item exists for this title, and reference ID if given.
+		var matched, unreferenced *syncedItem
+		for _, item := range itemsByTitle[key] {
+			if item.ReferenceId == referenceId || (referenceId == "" && matched == nil) {
+				matched = item
+			}
+			if item.ReferenceId == "" && unreferenced == nil {
+				unreferenced = item
+			}
+		}
+		if matched == nil && unreferenced != nil {
+			// An item without a reference ID can be given this one.
+			unreferenced.ReferenceId = referenceId
+			plan.Changes = append(plan.Changes, catalogChange{
+				Kind:        catalogSetReference,
+				TitleId:     unreferenced.TitleId,
+				ItemId:      unreferenced.ItemId,
+				ReferenceId: referenceId,
+			})
+		} else if matched == nil {
+			item := &syncedItem{TitleId: titleId, ReferenceId: referenceId}
+			itemsByTitle[key] = append(itemsByTitle[key], item)
+			plan.Changes = append(plan.Changes, catalogChange{
+				Kind:        catalogAddItem,
+				TitleId:     titleId,
+				ReferenceId: referenceId,
+			})
+		}
+
+		name := strings.TrimSpace(app.Name)
+		title, ok := titlesById[key]
+		if !ok {
+			if name == "" {
+				// Titles are named by their game code until otherwise specified, such as HADA.
+				name = titleId[8:]
+			}
+			title = syncedTitle{TitleId: titleId, Name: name, Version: -1}
+			plan.Changes = append(plan.Changes, catalogChange{
+				Kind:      catalogAddTitle,
+				TitleId:   titleId,
+				Name:      name,
+				Publisher: strings.TrimSpace(app.Author),
+			})
+		} else if name != "" && name != title.Name {
+			title.Name = name
+			plan.Changes = append(plan.Changes, catalogChange{
+				Kind:    catalogRenameTitle,
+				TitleId: titleId,
+				Name:    name,
+			})
+		}
+
+		if app.Shop.Version > title.Version {
+			title.Version = app.Shop.Version
+			plan.Changes = append(plan.Changes, catalogChange{
+				Kind:    catalogAddVersion,
+				TitleId: title.TitleId,
+				Version: app.Shop.Version,
+			})
+		}
+		titlesById[key] = title
+	}
+
+	for key, existing := range itemsByTitle {
+		if !listed[key] {
+			plan.Retained = append(plan.Retained, existing[0].TitleId)
+		}
+	}
+	sort.Strings(plan.Retained)
+
+	return plan
+}
+
+// querySyncState returns all items and titles currently within the catalog.
+func querySyncState(tx pgx.Tx) ([]syncedItem, map[string]syncedTitle, error) {
+	rows, err := tx.Query(ctx, QuerySyncedItemsStatement)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var items []syncedItem
+	for rows.Next() {
+		var item syncedItem
+		err = rows.Scan(&item.ItemId, &item.TitleId, &item.ReferenceId)
+		if err != nil {
+			return nil, nil, err
+		}
+		items = append(items, item)
+	}
+	if rows.Err() != nil {
+		return nil, nil, rows.Err()
+	}
+
+	titleRows, err := tx.Query(ctx, QuerySyncedTitlesStatement)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer titleRows.Close()
+
+	titles := map[string]syncedTitle{}
+	for titleRows.Next() {
+		var title syncedTitle
+		err = titleRows.Scan(&title.TitleId, &title.Name, &title.Version)
+		if err != nil {
+			return nil, nil, err
+		}
+		titles[title.TitleId] = title
+	}
+
+	return items, titles, titleRows.Err()
+}
+
+// applyCatalogSync makes all changes within the given plan, assigning IDs to any items created.
+// New items are given the given price, or are left unpriced if it is negative.
+func applyCatalogSync(tx pgx.Tx, plan *catalogSync, price int) error {
+	for i := range plan.Changes {
+		change := &plan.Changes[i]
+
+		var err error
+		switch change.Kind {
+		case catalogAddItem:
+			err = tx.QueryRow(ctx, ReserveServiceItemStatement).Scan(&change.ItemId)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(ctx, InsertServiceTitleStatement, change.ItemId, change.TitleId, nullString(change.ReferenceId))
+			if err == nil && price >= 0 {
+				_, err = tx.Exec(ctx, InsertCatalogPriceStatement, change.ItemId, importPricingCode, price)
+			}
+		case catalogAddTitle:
+			_, err = tx.Exec(ctx, InsertSyncedTitleStatement, change.TitleId, change.Name, nullString(change.Publisher))
+		case catalogRenameTitle:
+			_, err = tx.Exec(ctx, RenameCatalogTitleStatement, change.TitleId, change.Name)
+		case catalogAddVersion:
+			_, err = tx.Exec(ctx, InsertSyncedTitleVersionStatement, change.TitleId, change.Version)
+		case catalogSetReference:
+			_, err = tx.Exec(ctx, UpdateItemReferenceStatement, change.ItemId, change.ReferenceId)
+		}
+		if err != nil {
+			return fmt.Errorf("applying %s: %w", change, err)
+		}
+	}
+
+	return nil
+}
+
+// syncCatalog brings the catalog in line with the feed at the given source, returning the changes made.
+// Should dryRun be set, changes are determined but never committed.
+func syncCatalog(source string, price int, dryRun bool) (*catalogSync, error) {
+	apps, err := loadOSCApps(source)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	items, titles, err := querySyncState(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := planCatalogSync(items, titles, apps)
+	err = applyCatalogSync(tx, &plan, price)
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return &plan, nil
+	}
+	return &plan, tx.Commit(ctx)
+}
+
+// printCatalogSync reports the given changes via the given function, such as fmt.Printf or log.Printf.
+func printCatalogSync(plan *catalogSync, printf func(format string, args ...interface{})) {
+	for _, change := range plan.Changes {
+		printf("%s\n", change)
+	}
+	for _, titleId := range plan.Retained {
+		printf("= title %s is not within the feed, and is retained\n", titleId)
+	}
+	for _, reason := range plan.Skipped {
+		printf("! skipped, as %s\n", reason)
+	}
+}
+
+// catalogSource returns the feed the catalog is synced from, defaulting to the Open Shop Channel API.
+func catalogSource(configured string) string {
+	if configured == "" {
+		return defaultOSCAPIUrl
+	}
+	return configured
+}
+
+// runSyncCatalog handles the sync-catalog subcommand, importing titles from a feed into the catalog.
+func runSyncCatalog(args []string, configuredSource string) {
+	flags := flag.NewFlagSet("sync-catalog", flag.ExitOnError)
+	price := flags.Int("price", -1, "price in points for new items, which are otherwise left unpriced and cannot be listed or purchased")
+	dryRun := flags.Bool("dry-run", false, "report changes without making them")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: WiiSOAP sync-catalog [-price points] [-dry-run] [URL or file]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	source := catalogSource(configuredSource)
+	switch flags.NArg() {
+	case 0:
+	case 1:
+		source = flags.Arg(0)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	plan, err := syncCatalog(source, *price, *dryRun)
+	checkError(err)
+
+	printCatalogSync(plan, func(format string, args ...interface{}) {
+		fmt.Printf(format, args...)
+	})
+	if *dryRun {
+		fmt.Printf("[i] Dry run: %d change(s) from %s were not made.\n", len(plan.Changes), source)
+	} else {
+		fmt.Printf("[i] Made %d change(s) from %s.\n", len(plan.Changes), source)
+	}
+}
+
+// watchCatalog periodically syncs the catalog from the given source, leaving new items unpriced.
+func watchCatalog(source string, interval time.Duration) {
+	for {
+		plan, err := syncCatalog(source, -1, false)
+		if err != nil {
+			log.Printf("error syncing catalog from %s: %v\n", source, err)
+		} else if len(plan.Changes) > 0 {
+			log.Printf("Synced %d change(s) to the catalog from %s\n", len(plan.Changes), source)
+			printCatalogSync(plan, log.Printf)
+		}
+
+		time.Sleep(interval)
+	}
+}
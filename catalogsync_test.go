@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanCatalogSync(t *testing.T) {
+	items := []syncedItem{
+		{ItemId: 1, TitleId: "0001000148414441"},
+		{ItemId: 2, TitleId: "0001000548414441"},
+		{ItemId: 3, TitleId: "000100014843494A"},
+		{ItemId: 4, TitleId: "0001000148434E41"},
+	}
+	titles := map[string]syncedTitle{
+		"0001000148414441": {TitleId: "0001000148414441", Name: "Homebrew Browser", Version: 2},
+		"0001000548414441": {TitleId: "0001000548414441", Name: "Stage Pack", Version: 0},
+		"000100014843494A": {TitleId: "000100014843494A", Name: "Wii no Ma", Version: -1},
+	}
+	apps := []OSCApp{
+		// Unchanged.
+		{Name: "Homebrew Browser", Shop: Shop{TitleId: "0001000148414441", Version: 2}},
+		// Updated, and renamed.
+		{Name: "Homebrew Browser Stage Pack", Shop: Shop{TitleId: "0001000548414441", Version: 1}},
+		// An existing item is given a reference ID, and another is created for a second reference ID.
+		{Shop: Shop{TitleId: "000100014843494a", Version: 0, ReferenceId: "0123456789ABCDEF0123456789ABCDEF"}},
+		{Shop: Shop{TitleId: "000100014843494A", Version: 0, ReferenceId: "FEDCBA9876543210FEDCBA9876543210"}},
+		// Entirely new.
+		{Name: "Dolphin Demo", Author: "Open Shop Channel", Shop: Shop{TitleId: "0001000144454D4F", Version: 5}},
+		{Name: "Broken", Shop: Shop{TitleId: "not a title"}},
+	}
+
+	plan := planCatalogSync(items, titles, apps)
+	expected := []catalogChange{
+		{Kind: catalogRenameTitle, TitleId: "0001000548414441", Name: "Homebrew Browser Stage Pack"},
+		{Kind: catalogAddVersion, TitleId: "0001000548414441", Version: 1},
+		{Kind: catalogSetReference, TitleId: "000100014843494A", ItemId: 3, ReferenceId: "0123456789ABCDEF0123456789ABCDEF"},
+		{Kind: catalogAddVersion, TitleId: "000100014843494A", Version: 0},
+		{Kind: catalogAddItem, TitleId: "000100014843494A", ReferenceId: "FEDCBA9876543210FEDCBA9876543210"},
+		{Kind: catalogAddItem, TitleId: "0001000144454D4F"},
+		{Kind: catalogAddTitle, TitleId: "0001000144454D4F", Name: "Dolphin Demo", Publisher: "Open Shop Channel"},
+		{Kind: catalogAddVersion, TitleId: "0001000144454D4F", Version: 5},
+	}
+	if !reflect.DeepEqual(plan.Changes, expected) {
+		t.Errorf("planned changes:\n%v\nexpected:\n%v", plan.Changes, expected)
+	}
+
+	// Items for titles no longer within the feed must never be removed.
+	if !reflect.DeepEqual(plan.Retained, []string{"0001000148434E41"}) {
+		t.Errorf("retained %v, expected only 0001000148434E41", plan.Retained)
+	}
+	if len(plan.Skipped) != 1 {
+		t.Errorf("skipped %v, expected the invalid title ID", plan.Skipped)
+	}
+}
+
+func TestPlanCatalogSyncMixedCase(t *testing.T) {
+	// Titles imported prior to syncing may be stored in lowercase.
+	items := []syncedItem{
+		{ItemId: 1, TitleId: "000100014843494a"},
+		{ItemId: 2, TitleId: "0001000148434e41"},
+	}
+	titles := map[string]syncedTitle{
+		"000100014843494a": {TitleId: "000100014843494a", Name: "Wii no Ma", Version: 0},
+	}
+	apps := []OSCApp{
+		{Name: "Wii no Ma", Shop: Shop{TitleId: "000100014843494A", Version: 1, ReferenceId: "0123456789ABCDEF0123456789ABCDEF"}},
+		{Name: "Mixed", Shop: Shop{TitleId: "0001000148434E41", Version: 0}},
+	}
+
+	plan := planCatalogSync(items, titles, apps)
+	expected := []catalogChange{
+		{Kind: catalogSetReference, TitleId: "000100014843494a", ItemId: 1, ReferenceId: "0123456789ABCDEF0123456789ABCDEF"},
+		{Kind: catalogAddVersion, TitleId: "000100014843494a", Version: 1},
+		{Kind: catalogAddTitle, TitleId: "0001000148434e41", Name: "Mixed"},
+		{Kind: catalogAddVersion, TitleId: "0001000148434e41", Version: 0},
+	}
+	if !reflect.DeepEqual(plan.Changes, expected) {
+		t.Errorf("planned changes:\n%v\nexpected:\n%v", plan.Changes, expected)
+	}
+	if len(plan.Retained) != 0 {
+		t.Errorf("retained %v, expected every title to match regardless of case", plan.Retained)
+	}
+}
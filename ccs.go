@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/logrusorgru/aurora/v3"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// ccsDownloadPath is the path consoles download title contents from, as given by contentPrefixUrl.
+	ccsDownloadPath = "/ccs/download/"
+
+	// immutableCacheControl is sent for files whose contents never change for the same name,
+	// such as contents and TMDs of a specific version.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// revalidateCacheControl is sent for files that change as a title is updated,
+	// such as the current TMD and the common ticket.
+	revalidateCacheControl = "no-cache"
+)
+
+// ccsFilePattern matches the files consoles may request for a title:
+// the current TMD, a TMD of a specific version, the common ticket, or a content by its ID.
+var ccsFilePattern = regexp.MustCompile(`^(tmd|tmd\.[0-9]{1,5}|cetk|[0-9a-f]{8})$`)
+
+var errInvalidContentPath = errors.New("invalid content path")
+
+// contentPath returns the file within the given content root for the requested download path,
+// alongside whether its contents may be cached indefinitely.
+// Files are laid out as <root>/<title ID>/<file>, with both in lowercase.
+func contentPath(root string, path string) (string, bool, error) {
+	titleId, file, ok := strings.Cut(strings.TrimPrefix(path, ccsDownloadPath), "/")
+	if !ok {
+		return "", false, errInvalidContentPath
+	}
+
+	titleId = strings.ToLower(titleId)
+	if len(titleId) != 16 || !isHex(titleId) {
+		return "", false, errInvalidContentPath
+	}
+	file = strings.ToLower(file)
+	if !ccsFilePattern.MatchString(file) {
+		return "", false, errInvalidContentPath
+	}
+
+	immutable := file != "tmd" && file != "cetk"
+	return filepath.Join(root, titleId, file), immutable, nil
+}
+
+// isHex returns whether the given string only contains lowercase hexadecimal characters.
+func isHex(value string) bool {
+	for _, c := range value {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// contentHandler serves title contents from the given content root, akin to Nintendo's CCS.
+// Range requests and conditional requests are handled by http.ServeContent.
+func contentHandler(root string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s via %s", aurora.Yellow(r.Method), aurora.Cyan(r.URL), aurora.Cyan(r.Host))
+
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		path, immutable, err := contentPath(root, r.URL.Path)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			log.Printf("error opening content %s: %v", path, err)
+			http.Error(w, "error reading content", http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		if immutable {
+			w.Header().Set("Cache-Control", immutableCacheControl)
+		} else {
+			w.Header().Set("Cache-Control", revalidateCacheControl)
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+		http.ServeContent(w, r, "", info.ModTime(), file)
+	})
+}
+
+// newServeMux returns a handler serving SOAP actions via the given route,
+// alongside title contents should a content root be configured.
+func newServeMux(r Route, contentRoot string) http.Handler {
+	mux := http.NewServeMux()
+	if contentRoot != "" {
+		mux.Handle(ccsDownloadPath, contentHandler(contentRoot))
+	}
+	mux.Handle("/", r.Handle())
+	return mux
+}
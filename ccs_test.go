@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestContentPath(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/ccs/download/0001000148414441/tmd":       "0001000148414441/tmd",
+		"/ccs/download/0001000148414441/tmd.1024":  "0001000148414441/tmd.1024",
+		"/ccs/download/0001000148414441/CETK":      "0001000148414441/cetk",
+		"/ccs/download/000100014841444A/0000000A":  "000100014841444a/0000000a",
+		"/ccs/download/0001000148414441/../tmd":    "",
+		"/ccs/download/0001000148414441/title.wad": "",
+		"/ccs/download/00010001/00000000":          "",
+		"/ccs/download/0001000148414441":           "",
+	} {
+		actual, _, err := contentPath("root", path)
+		if expected == "" {
+			if err == nil {
+				t.Errorf("contentPath(%q) = %q; expected an error", path, actual)
+			}
+		} else if actual != filepath.Join("root", expected) {
+			t.Errorf("contentPath(%q) = %q, %v; expected %q", path, actual, err, expected)
+		}
+	}
+}
+
+func TestContentHandler(t *testing.T) {
+	previousOutput := log.Writer()
+	defer log.SetOutput(previousOutput)
+	log.SetOutput(io.Discard)
+
+	root := t.TempDir()
+	titleDir := filepath.Join(root, "0001000148414441")
+	if err := os.Mkdir(titleDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{
+		"tmd":      "current tmd",
+		"00000001": "0123456789",
+	} {
+		if err := os.WriteFile(filepath.Join(titleDir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := contentHandler(root)
+
+	for _, test := range []struct {
+		path         string
+		rangeHeader  string
+		status       int
+		body         string
+		cacheControl string
+	}{
+		{"/ccs/download/0001000148414441/tmd", "", http.StatusOK, "current tmd", revalidateCacheControl},
+		{"/ccs/download/0001000148414441/00000001", "", http.StatusOK, "0123456789", immutableCacheControl},
+		{"/ccs/download/0001000148414441/00000001", "bytes=2-5", http.StatusPartialContent, "2345", immutableCacheControl},
+		{"/ccs/download/0001000148414441/00000002", "", http.StatusNotFound, "", ""},
+		{"/ccs/download/0001000148414441/cetk", "", http.StatusNotFound, "", ""},
+	} {
+		request := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.rangeHeader != "" {
+			request.Header.Set("Range", test.rangeHeader)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.status {
+			t.Errorf("%s (Range %q): status %d, expected %d", test.path, test.rangeHeader, recorder.Code, test.status)
+			continue
+		}
+		if test.status == http.StatusNotFound {
+			continue
+		}
+		if body := recorder.Body.String(); body != test.body {
+			t.Errorf("%s (Range %q): body %q, expected %q", test.path, test.rangeHeader, body, test.body)
+		}
+		if cacheControl := recorder.Header().Get("Cache-Control"); cacheControl != test.cacheControl {
+			t.Errorf("%s: Cache-Control %q, expected %q", test.path, cacheControl, test.cacheControl)
+		}
+	}
+
+	// Conditional requests should be answered without a body.
+	request := httptest.NewRequest(http.MethodGet, "/ccs/download/0001000148414441/00000001", nil)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	request.Header.Set("If-None-Match", recorder.Header().Get("ETag"))
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("conditional request: status %d, expected %d", recorder.Code, http.StatusNotModified)
+	}
+}
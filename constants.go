@@ -6,15 +6,27 @@ import "errors"
 type LimitKinds int
 
 const (
-	// PR is presumably "purchased".
+	// PR is presumably "purchased", a permanent licence without limits.
 	PR LimitKinds = 0
-	TR            = 1
-	DR            = 2
-	SR            = 3
-	LR            = 4
-	AT            = 10000
+	// TR limits the amount of minutes a title may be played for.
+	TR LimitKinds = 1
+	DR LimitKinds = 2
+	SR LimitKinds = 3
+	// LR limits the amount of times a title may be launched.
+	LR LimitKinds = 4
+	AT LimitKinds = 10000
 )
 
+// limitKindNames maps limit kinds to their names, as reported to consoles.
+var limitKindNames = map[LimitKinds]string{
+	PR: "PR",
+	TR: "TR",
+	DR: "DR",
+	SR: "SR",
+	LR: "LR",
+	AT: "AT",
+}
+
 // LicenceKinds represents the various rights a user has to a title.
 type LicenceKinds string
 
@@ -44,20 +56,28 @@ func GetLicenceKind(kind string) (*LicenceKinds, error) {
 	}
 }
 
-// LimitStruct returns a Limits struct filled for the given kind.
-func LimitStruct(kind LimitKinds) Limits {
-	names := map[LimitKinds]string{
-		PR: "PR",
-		TR: "TR",
-		DR: "DR",
-		SR: "SR",
-		LR: "LR",
-		AT: "AT",
+// GetLimitKind returns the limit kind of the given name, such as "TR".
+func GetLimitKind(name string) (*LimitKinds, error) {
+	for kind, kindName := range limitKindNames {
+		if kindName == name {
+			return &kind, nil
+		}
 	}
 
+	return nil, errors.New("invalid LimitKind")
+}
+
+// LimitStruct returns a Limits struct filled for the given kind, without a value.
+func LimitStruct(kind LimitKinds) Limits {
+	return LimitValueStruct(kind, 0)
+}
+
+// LimitValueStruct returns a Limits struct filled for the given kind and value,
+// such as the amount of minutes a rental may be played for.
+func LimitValueStruct(kind LimitKinds, value int) Limits {
 	return Limits{
-		Limits:    kind,
-		LimitKind: names[kind],
+		Limits:    value,
+		LimitKind: limitKindNames[kind],
 	}
 }
 
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/wii-tools/wadlib"
+)
+
+const (
+	QueryItemContentsStatement = `SELECT service_titles.item_id, catalog_content_set_contents.content_index
+		FROM service_titles, catalog_content_set_contents
+		WHERE service_titles.content_set_id = catalog_content_set_contents.content_set_id
+		AND service_titles.item_id = ANY($1)
+		ORDER BY service_titles.item_id, catalog_content_set_contents.content_index`
+
+	QueryOwnedContentsStatement = `SELECT content_index
+		FROM owned_contents
+		WHERE account_id = $1 AND title_id = $2
+		ORDER BY content_index`
+
+	StoreOwnedContentStatement = `INSERT INTO owned_contents (account_id, title_id, content_index)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING`
+
+	RemoveOwnedContentsStatement = `DELETE FROM owned_contents WHERE account_id = $1`
+)
+
+const (
+	// ticketContentMaskOffset is the offset of the content access mask within the ticket,
+	// relative to wadlib.Ticket's Unknown field.
+	ticketContentMaskOffset = 48
+	// ticketContentMaskSize is the size of the content access mask, permitting one bit per content index.
+	ticketContentMaskSize = 64
+)
+
+// setContentMask permits the given ticket to access only the given content indexes.
+func setContentMask(ticket *wadlib.Ticket, indexes []int) error {
+	mask := ticket.Unknown[ticketContentMaskOffset : ticketContentMaskOffset+ticketContentMaskSize]
+	for i := range mask {
+		mask[i] = 0
+	}
+
+	for _, index := range indexes {
+		if index < 0 || index >= ticketContentMaskSize*8 {
+			return fmt.Errorf("content index %d cannot be represented within a ticket", index)
+		}
+		mask[index/8] |= 1 << (index % 8)
+	}
+
+	return nil
+}
+
+// permitsWholeTitle determines whether the given ticket permits access to every content within its title,
+// as our ticket template does.
+func permitsWholeTitle(ticket *wadlib.Ticket) bool {
+	var template wadlib.Ticket
+	err := binary.Read(bytes.NewReader(wadlib.TicketTemplate), binary.BigEndian, &template)
+	if err != nil {
+		return false
+	}
+
+	mask := ticket.Unknown[ticketContentMaskOffset : ticketContentMaskOffset+ticketContentMaskSize]
+	return bytes.Equal(mask, template.Unknown[ticketContentMaskOffset:ticketContentMaskOffset+ticketContentMaskSize])
+}
+
+// mergeContents returns the sorted union of the given content indexes,
+// such as those previously owned alongside those purchased.
+func mergeContents(owned []int, purchased []int) []int {
+	seen := map[int]bool{}
+	var merged []int
+	for _, index := range append(append([]int{}, owned...), purchased...) {
+		if !seen[index] {
+			seen[index] = true
+			merged = append(merged, index)
+		}
+	}
+
+	sort.Ints(merged)
+	return merged
+}
+
+// queryItemContents returns the content indexes granted by each of the given items.
+// Items granting their title as a whole are omitted.
+func queryItemContents(q querier, itemIds []int) (map[int][]int, error) {
+	rows, err := q.Query(ctx, QueryItemContentsStatement, itemIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contents := map[int][]int{}
+	for rows.Next() {
+		var itemId, contentIndex int
+		err = rows.Scan(&itemId, &contentIndex)
+		if err != nil {
+			return nil, err
+		}
+
+		contents[itemId] = append(contents[itemId], contentIndex)
+	}
+
+	return contents, rows.Err()
+}
+
+// queryOwnedContents returns all content indexes within the given title purchased by an account.
+func queryOwnedContents(q querier, accountId int64, titleId string) ([]int, error) {
+	rows, err := q.Query(ctx, QueryOwnedContentsStatement, accountId, titleId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var contents []int
+	for rows.Next() {
+		var contentIndex int
+		err = rows.Scan(&contentIndex)
+		if err != nil {
+			return nil, err
+		}
+
+		contents = append(contents, contentIndex)
+	}
+
+	return contents, rows.Err()
+}
+
+// storeOwnedContents records the given content indexes within a title as owned by an account.
+func storeOwnedContents(q querier, accountId int64, titleId string, contents []int) error {
+	for _, contentIndex := range contents {
+		_, err := q.Exec(ctx, StoreOwnedContentStatement, accountId, titleId, contentIndex)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
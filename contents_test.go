@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/wii-tools/wadlib"
+)
+
+func TestContentMask(t *testing.T) {
+	// A repeat purchase extends access to the contents previously purchased.
+	contents := mergeContents([]int{1, 2}, []int{3, 2, 9})
+	if !reflect.DeepEqual(contents, []int{1, 2, 3, 9}) {
+		t.Fatalf("merged %v, expected [1 2 3 9]", contents)
+	}
+
+	var ticket wadlib.Ticket
+	mask := ticket.Unknown[ticketContentMaskOffset : ticketContentMaskOffset+ticketContentMaskSize]
+	for i := range mask {
+		mask[i] = 0xFF
+	}
+
+	err := setContentMask(&ticket, contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := make([]byte, ticketContentMaskSize)
+	expected[0] = 0x0E
+	expected[1] = 0x02
+	if !reflect.DeepEqual(mask, expected) {
+		t.Errorf("content mask %x, expected %x", mask, expected)
+	}
+
+	if err = setContentMask(&ticket, []int{ticketContentMaskSize * 8}); err == nil {
+		t.Error("expected an unrepresentable content index to be rejected")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import "fmt"
+
+// ErrorCode is reported within a response's ErrorCode element when an action fails.
+// The EC library displays it to the user, and titles may check for specific values,
+// so a code must never change meaning once in use.
+type ErrorCode int
+
+// Codes shared across all services.
+const (
+	// ErrorCodeServerError is returned when an unexpected server-side failure occurs, such as a database error.
+	ErrorCodeServerError ErrorCode = 2
+	// ErrorCodeInvalidRequest is returned when a field is missing, or holds a value we cannot interpret.
+	ErrorCodeInvalidRequest ErrorCode = 5
+	// ErrorCodeTitleNotFound is returned when a title is not within our catalog.
+	ErrorCodeTitleNotFound ErrorCode = 9
+	// ErrorCodeAuthenticationFailed is returned when a device token does not belong to the given account and console.
+	ErrorCodeAuthenticationFailed ErrorCode = 901
+)
+
+// Codes specific to ecs.
+const (
+	// ECGiftDeclined is returned when a console declines a gift.
+	ECGiftDeclined ErrorCode = 10
+	// ECPointsFailed is returned when an account's points balance could not be adjusted.
+	ECPointsFailed ErrorCode = 103
+	// ECBalanceUnavailable is returned when an account's points balance could not be retrieved.
+	ECBalanceUnavailable ErrorCode = 104
+	// ECPointsPurchaseFailed is returned when a points purchase could not be recorded.
+	ECPointsPurchaseFailed ErrorCode = 113
+	// ECGiftFailed is returned when a gift could not be recorded.
+	ECGiftFailed ErrorCode = 123
+	// ECInvalidGiftNotes is returned when the notes accompanying a gift do not name its sender.
+	ECInvalidGiftNotes ErrorCode = 124
+	// ECGiftNotFound is returned when a gift does not exist, or could not be accepted.
+	ECGiftNotFound ErrorCode = 143
+	// ECUnknownPointsItem is returned when points are purchased with an item that does not grant any.
+	ECUnknownPointsItem ErrorCode = 201
+	// ECInsufficientBalance is returned when an account cannot afford a purchase.
+	ECInsufficientBalance ErrorCode = 642
+)
+
+// Codes specific to ias.
+const (
+	// IASUnregisterFailed is returned when an account could not be removed from its console.
+	IASUnregisterFailed ErrorCode = 7
+	// IASMoveFailed is returned when an account could not be moved to another console.
+	IASMoveFailed ErrorCode = 8
+	// IASDeviceNotRegistered is returned when a console synchronises without being registered.
+	IASDeviceNotRegistered ErrorCode = 107
+	// IASMissingDeviceCode is returned when a console registers without its friend code.
+	IASMissingDeviceCode ErrorCode = 117
+	// IASMissingRegisterRegion is returned when a console registers without a region.
+	IASMissingRegisterRegion ErrorCode = 127
+	// IASRegionMismatch is returned when a console registers for a region other than its own.
+	IASRegionMismatch ErrorCode = 137
+	// IASMissingSerialNumber is returned when a console registers without its serial number.
+	IASMissingSerialNumber ErrorCode = 147
+	// IASInvalidDeviceCode is returned when a console registers with an invalid friend code.
+	IASInvalidDeviceCode ErrorCode = 157
+	// IASAccountExists is returned when a console registers more than once.
+	IASAccountExists ErrorCode = 177
+	// IASRegistrationFailed is returned when an account could not be created.
+	IASRegistrationFailed ErrorCode = 187
+	// IASMigrateLimitReached is returned when an account's tickets may no longer be moved to another console.
+	IASMigrateLimitReached ErrorCode = 908
+	// IASDeviceNotPermitted is returned when a console is blocked, or not whitelisted.
+	IASDeviceNotPermitted ErrorCode = 909
+)
+
+// ActionError describes why an action failed.
+// It is reported to the console within the response's ErrorCode and ErrorMessage.
+type ActionError struct {
+	Code   ErrorCode
+	Reason string
+	Err    error
+}
+
+func (a *ActionError) Error() string {
+	return fmt.Sprintf("%s: %v", a.Reason, a.Err)
+}
+
+func (a *ActionError) Unwrap() error {
+	return a.Err
+}
+
+// actionError returns an ActionError with the given code, describing why an action failed.
+// Handlers should return it immediately, ending the action.
+func actionError(code ErrorCode, reason string, err error) error {
+	return &ActionError{
+		Code:   code,
+		Reason: reason,
+		Err:    err,
+	}
+}
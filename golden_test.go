@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// update rewrites all recorded responses with those currently produced.
+// Run `go test -run TestGolden -update` after an intended change, and review the resulting diff.
+var update = flag.Bool("update", false, "rewrite golden responses within testdata")
+
+const (
+	// goldenDir contains hand-written requests modelled on those consoles send, alongside the responses WiiSOAP
+	// previously produced for them. Responses are recorded with -update, and are snapshots of our own behaviour:
+	// they are not captured from, nor validated against, consoles or the original servers.
+	// Cases are named testdata/golden/<service>/<Action>[_Variant].request.xml,
+	// and their responses <Action>[_Variant].response.xml.
+	goldenDir = "testdata/golden"
+
+	testAccountId    = 123456789
+	testDeviceId     = 4041198519
+	testSerialNumber = "LU521024963"
+	// testDeviceToken is sent as ST-aech1kae4sheequ8Zohwa, or hashed as WT-3744f2846dc22b16b593b8d8c2831e2b.
+	testDeviceToken       = "aech1kae4sheequ8Zohwa"
+	testDeviceTokenHashed = "3744f2846dc22b16b593b8d8c2831e2b"
+
+	// The target console our account moves to registers as its own account beforehand.
+	testTargetAccountId    = 223456789
+	testTargetDeviceId     = 4041198520
+	testTargetSerialNumber = "LU521024964"
+	// testTargetDeviceToken is sent as ST-oi3ohZee8ahngei5Thee0.
+	testTargetDeviceToken       = "oi3ohZee8ahngei5Thee0"
+	testTargetDeviceTokenHashed = "e981b553cd3ec8d760c85d26735b8c62"
+
+	testAppTitleId     = "0001000148414441"
+	testDLCTitleId     = "0001000548414441"
+	testWiinoMaRefId   = "0123456789ABCDEF0123456789ABCDEF"
+	testExpiredRefId   = "FEDCBA9876543210FEDCBA9876543210"
+	testSenderDeviceId = "7000000000000104"
+	testDeviceCode     = "7000000000000112"
+)
+
+// volatileElements lists elements whose values differ between runs for a given case,
+// such as randomly generated values. Their contents are not compared.
+var volatileElements = map[string][]string{
+	"ias/Register": {"AccountId", "DeviceToken"},
+	// Subscriptions expire relative to the time of purchase.
+	"ecs/PurchaseTitle_WiiNoMa": {"ETickets"},
+}
+
+var timestampElement = regexp.MustCompile(`<TimeStamp>(\d+)</TimeStamp>`)
+
+// newGoldenStore returns a memoryStore holding a single registered console,
+// alongside a catalog containing a channel and its DLC. Cases needing further state apply goldenFixtures.
+func newGoldenStore() *memoryStore {
+	s := newMemoryStore()
+
+	s.users[testAccountId] = &User{
+		AccountId:         testAccountId,
+		DeviceId:          testDeviceId,
+		DeviceToken:       testDeviceToken,
+		DeviceTokenHashed: testDeviceTokenHashed,
+		Region:            "USA",
+		SerialNumber:      testSerialNumber,
+		OriginalTitle:     "WiiMart",
+		DeviceCode:        testDeviceCode,
+	}
+	s.balances[testAccountId] = 2000
+	s.nextTransaction = 10000004
+
+	s.items[1] = &memoryItem{ItemId: 1, TitleId: testAppTitleId, Prices: []memoryPrice{
+		{PricingCode: 1, Price: Prices{ItemId: 1, Price: Price{Amount: 500, Currency: "POINTS"}, Limits: LimitStruct(PR), LicenseKind: PERMANENT}},
+	}}
+	s.items[2] = &memoryItem{ItemId: 2, TitleId: testDLCTitleId, Prices: []memoryPrice{
+		{PricingCode: 1, Price: Prices{ItemId: 2, Price: Price{Amount: 200, Currency: "POINTS"}, Limits: LimitStruct(PR), LicenseKind: PERMANENT}},
+	}}
+
+	games := Category{CategoryCode: "01", CategoryName: "Games"}
+	s.categories[games.CategoryCode] = games
+	s.categories["02"] = Category{CategoryCode: "02", CategoryName: "Utilities"}
+	s.titles[testAppTitleId] = &TitleInfo{
+		TitleId:      testAppTitleId,
+		TitleName:    "Homebrew Browser",
+		Platform:     "WII",
+		Publisher:    "Open Shop Channel",
+		TitleVersion: 2,
+		TitleSize:    2097152,
+		Ratings:      []Ratings{{Name: "ESRB", Rating: 1, Age: 6}},
+		Categories:   []Category{games},
+		Contents: []ContentInfo{
+			{ContentIndex: 0, ContentId: "00000000", ContentSize: 1048576},
+			{ContentIndex: 1, ContentId: "00000001", ContentSize: 1048576},
+		},
+	}
+	s.titles[testDLCTitleId] = &TitleInfo{
+		TitleId:      testDLCTitleId,
+		TitleName:    "Homebrew Browser Stage Pack",
+		Platform:     "WII",
+		Publisher:    "Open Shop Channel",
+		TitleVersion: 0,
+		Categories:   []Category{games},
+	}
+	s.contentSets[testDLCTitleId] = []ContentSet{
+		{ContentSetId: 1, Name: "Stage Pack 1", ContentIndexes: []int{1, 2}},
+		{ContentSetId: 2, Name: "Stage Pack 2", ContentIndexes: []int{3}},
+	}
+
+	return s
+}
+
+// goldenFixtures lists the fixtures applied atop newGoldenStore for each case requiring them.
+var goldenFixtures = map[string][]func(s *memoryStore){
+	"cas/ListItems":                              {withContentSetItem, withUnpricedItem},
+	"cas/ListItems_DLC":                          {withContentSetItem},
+	"cas/ListItems_Rental":                       {withRental},
+	"ecs/AcceptGiftTitle":                        {withPendingGift},
+	"ecs/AcceptGiftTitle_OtherRecipient":         {withMisaddressedGift},
+	"ecs/AcceptGiftTitle_Updated":                {withPendingGift, withUpdatedDLC},
+	"ecs/GetETickets":                            {withPurchases},
+	"ecs/GiftTitle_ContentSet":                   {withContentSetItem},
+	"ecs/GiftTitle_Rental":                       {withRental},
+	"ecs/ListETickets":                           {withPurchases},
+	"ecs/ListPurchaseHistory":                    {withPurchases},
+	"ecs/ListPurchaseHistory_Title":              {withPurchases},
+	"ecs/ListPurchaseHistory_WiiNoMa":            {withPurchases},
+	"ecs/PurchaseTitle_ContentSet":               {withContentSetItem},
+	"ecs/PurchaseTitle_ContentSetOwned":          {withContentSetItem, withOwnedItem(2)},
+	"ecs/PurchaseTitle_InsufficientBalance":      {withBalance(100)},
+	"ecs/PurchaseTitle_Rental":                   {withRental},
+	"ecs/PurchaseTitle_RentalAfterPurchase":      {withRental, withOwnedItem(1)},
+	"ecs/PurchaseTitle_Unpriced":                 {withUnpricedItem},
+	"ecs/PurchaseTitle_WiiNoMa":                  {withPurchases},
+	"ecs/PurchaseTitle_WiiNoMaPriceMismatch":     {withPurchases},
+	"ecs/PurchaseTitle_WiiNoMaReferenceMismatch": {withPurchases},
+	"ias/MoveAccount":                            {withTargetConsole("USA")},
+	"ias/MoveAccount_RegionMismatch":             {withTargetConsole("EUR")},
+	"ias/MoveAccount_LimitReached":               {withTargetConsole("USA"), withMovedAccount},
+}
+
+// withWiinoMa lists the Wii no Ma theatre within our catalog, sold as a 30 day subscription.
+func withWiinoMa(s *memoryStore) {
+	s.items[3] = &memoryItem{ItemId: 3, TitleId: WiinoMaServiceTitleID, ReferenceId: testWiinoMaRefId, Prices: []memoryPrice{
+		{PricingCode: 1, Price: Prices{ItemId: 3, Price: Price{Amount: 100, Currency: "POINTS"}, Limits: LimitStruct(PR), LicenseKind: SERVICE}},
+	}}
+	s.plans[WiinoMaServiceTitleID] = subscriptionPlan{DurationDays: 30, Renewal: RenewReset}
+}
+
+// withPurchases records the purchase of our channel and two Wii no Ma theatres, one of which has expired.
+func withPurchases(s *memoryStore) {
+	withWiinoMa(s)
+	purchased := time.Date(2023, time.March, 14, 12, 0, 0, 0, time.UTC)
+
+	s.owned[testAccountId] = []memoryOwnedTitle{
+		{AccountId: testAccountId, TitleId: testAppTitleId, Version: 2, ItemId: 1, DatePurchased: purchased},
+		{AccountId: testAccountId, TitleId: WiinoMaServiceTitleID, ItemId: 3, DatePurchased: purchased.AddDate(0, 0, 2)},
+	}
+	s.transactions[testAccountId] = []transactionRecord{
+		{TransactionId: 10000000, Type: TransactionPurchaseGame, TitleId: testAppTitleId, ItemId: 1, TotalPaid: 500, Currency: "POINTS", Date: purchased},
+		{TransactionId: 10000001, Type: TransactionPurchaseGame, TitleId: WiinoMaServiceTitleID, ItemId: 3, TotalPaid: 100, Currency: "POINTS", ReferenceId: testWiinoMaRefId, Date: purchased.AddDate(0, 0, 2)},
+		// This theatre has since expired, and should no longer be listed.
+		{TransactionId: 10000002, Type: TransactionPurchaseGame, TitleId: WiinoMaServiceTitleID, ItemId: 4, TotalPaid: 100, Currency: "POINTS", ReferenceId: testExpiredRefId, Date: purchased.AddDate(0, 0, 1)},
+	}
+	s.subscriptions[testAccountId] = map[int]*subscription{
+		3: {ItemId: 3, TitleId: WiinoMaServiceTitleID, ReferenceId: testWiinoMaRefId, DateExpires: time.Now().AddDate(0, 0, 10)},
+		4: {ItemId: 4, TitleId: WiinoMaServiceTitleID, ReferenceId: testExpiredRefId, DateExpires: purchased.AddDate(0, 0, 31)},
+	}
+}
+
+// withBalance replaces the points balance of our account.
+func withBalance(points int) func(s *memoryStore) {
+	return func(s *memoryStore) {
+		s.balances[testAccountId] = points
+	}
+}
+
+// withPendingGift has another console gift our DLC to us, awaiting acceptance.
+func withPendingGift(s *memoryStore) {
+	s.gifts[memoryGiftKey{TitleId: testDLCTitleId, TransactionId: "10000003"}] = memoryGift{
+		ItemId:              2,
+		SenderFriendCode:    testSenderDeviceId,
+		RecipientFriendCode: testDeviceCode,
+	}
+}
+
+// withUpdatedDLC publishes a later version of our DLC within the catalog.
+func withUpdatedDLC(s *memoryStore) {
+	s.titles[testDLCTitleId].TitleVersion = 3
+}
+
+// withMisaddressedGift has another console gift our DLC to a third console, awaiting acceptance.
+func withMisaddressedGift(s *memoryStore) {
+	s.gifts[memoryGiftKey{TitleId: testDLCTitleId, TransactionId: "10000003"}] = memoryGift{
+		ItemId:              2,
+		SenderFriendCode:    testSenderDeviceId,
+		RecipientFriendCode: testSenderDeviceId,
+	}
+}
+
+// withTargetConsole registers the console our account moves to within the given region.
+func withTargetConsole(region string) func(s *memoryStore) {
+	return func(s *memoryStore) {
+		s.users[testTargetAccountId] = &User{
+			AccountId:         testTargetAccountId,
+			DeviceId:          testTargetDeviceId,
+			DeviceToken:       testTargetDeviceToken,
+			DeviceTokenHashed: testTargetDeviceTokenHashed,
+			Region:            region,
+			SerialNumber:      testTargetSerialNumber,
+			OriginalTitle:     "WiiMart",
+		}
+	}
+}
+
+// withMovedAccount has our account moved between consoles as often as permitted, though it holds no tickets.
+func withMovedAccount(s *memoryStore) {
+	s.migrations[testAccountId] = migrateLimit
+}
+
+// withUnpricedItem lists a second item for our channel, such as one synced from a feed, without any price.
+func withUnpricedItem(s *memoryStore) {
+	s.items[6] = &memoryItem{ItemId: 6, TitleId: testAppTitleId}
+}
+
+// withOwnedItem has the given item previously purchased outright, with a ticket for its whole title issued.
+func withOwnedItem(itemId int) func(s *memoryStore) {
+	return func(s *memoryStore) {
+		item := s.items[itemId]
+		version := s.titles[item.TitleId].TitleVersion
+		grant, err := newTicketGrant(testAccountId, testDeviceId, item.TitleId, version)
+		if err != nil {
+			panic(err)
+		}
+		pricing := item.Prices[0].Price
+		s.PurchaseTitle(testAccountId, &transactionRecord{
+			Type:        TransactionPurchaseGame,
+			TitleId:     item.TitleId,
+			ItemId:      itemId,
+			TotalPaid:   pricing.Price.Amount,
+			LicenseKind: pricing.LicenseKind,
+			Limits:      pricing.Limits,
+		}, version, grant, nil)
+	}
+}
+
+// withRental permits our channel to be rented for an hour of play.
+func withRental(s *memoryStore) {
+	s.items[1].Prices = append(s.items[1].Prices, memoryPrice{
+		PricingCode: 2, Price: Prices{ItemId: 1, Price: Price{Amount: 100, Currency: "POINTS"}, Limits: LimitValueStruct(TR, 60), LicenseKind: RENTAL},
+	})
+}
+
+// withContentSetItem permits the second stage pack of our DLC to be purchased alone.
+func withContentSetItem(s *memoryStore) {
+	s.items[5] = &memoryItem{ItemId: 5, TitleId: testDLCTitleId, ContentSetId: 2, Prices: []memoryPrice{
+		{PricingCode: 1, Price: Prices{ItemId: 5, Price: Price{Amount: 150, Currency: "POINTS"}, Limits: LimitStruct(PR), LicenseKind: PERMANENT}},
+	}}
+}
+
+// normaliseResponse replaces values which differ between runs so that responses may be compared.
+func normaliseResponse(name string, response string) string {
+	if match := timestampElement.FindStringSubmatch(response); match != nil {
+		response = strings.ReplaceAll(response, match[1], "{TimeStamp}")
+	}
+
+	for _, element := range volatileElements[name] {
+		volatile := regexp.MustCompile("<" + element + ">[^<]*</" + element + ">")
+		response = volatile.ReplaceAllString(response, "<"+element+">{"+element+"}</"+element+">")
+	}
+
+	return response
+}
+
+// TestGolden replays requests against our handlers, and compares responses with those previously recorded,
+// so that any change in behaviour is deliberate. Passing says nothing about whether consoles accept them.
+func TestGolden(t *testing.T) {
+	previousStore, previousProvider, previousBaseUrl, previousSystemTitles, previousOutput := store, titleProvider, baseUrl, systemTitles, log.Writer()
+	defer func() {
+		store, titleProvider, baseUrl, systemTitles = previousStore, previousProvider, previousBaseUrl, previousSystemTitles
+		log.SetOutput(previousOutput)
+	}()
+	// Purchases are validated against the titles within our golden store.
+	titleProvider = catalogTitleProvider{}
+	baseUrl = "wiimart.example"
+	err := loadSystemTitles([]SystemTitle{
+		{Region: "USA", TitleId: "0000000100000002", Version: 513},
+		{Region: "JPN", TitleId: "0000000100000002", Version: 512},
+		{TitleId: "0000000100000050", Version: 7200, FsSize: 1179648},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.SetOutput(io.Discard)
+
+	requests, err := filepath.Glob(filepath.Join(goldenDir, "*", "*.request.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) == 0 {
+		t.Fatal("no golden cases were found")
+	}
+
+	router := newRouter()
+	handler := router.Handle()
+	for _, requestPath := range requests {
+		service := filepath.Base(filepath.Dir(requestPath))
+		caseName := strings.TrimSuffix(filepath.Base(requestPath), ".request.xml")
+		action, _, _ := strings.Cut(caseName, "_")
+		name := service + "/" + caseName
+
+		t.Run(name, func(t *testing.T) {
+			// Every case begins with the same state, alongside any fixtures it requires.
+			goldenStore := newGoldenStore()
+			for _, fixture := range goldenFixtures[name] {
+				fixture(goldenStore)
+			}
+			store = goldenStore
+
+			body, err := os.ReadFile(requestPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/"+service+"/services/"+action, bytes.NewReader(body))
+			r.Header.Set("SOAPAction", "urn:"+service+".wsapi.broadon.com/"+action)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			// Faults are sent with a 500 status, and all other responses with a 200 status.
+			expectedStatus := http.StatusOK
+			if strings.Contains(w.Body.String(), "<soapenv:Fault>") {
+				expectedStatus = http.StatusInternalServerError
+			}
+			if w.Code != expectedStatus {
+				t.Errorf("expected status %d, got %d", expectedStatus, w.Code)
+			}
+
+			actual := normaliseResponse(name, w.Body.String())
+			responsePath := strings.TrimSuffix(requestPath, ".request.xml") + ".response.xml"
+			if *update {
+				err = os.WriteFile(responsePath, []byte(actual), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			expected, err := os.ReadFile(responsePath)
+			if err != nil {
+				t.Fatalf("missing recorded response, run with -update to record: %v", err)
+			}
+			if actual != string(expected) {
+				t.Errorf("response differs from %s\nexpected:\n%s\nactual:\n%s", responsePath, expected, actual)
+			}
+		})
+	}
+}
@@ -18,175 +18,127 @@
 package main
 
 import (
-	"bufio"
 	"crypto/md5"
 	"errors"
 	"fmt"
 	"log"
 	"math/rand"
-	"os"
-	"slices"
 	"strconv"
 
 	wiino "github.com/RiiConnect24/wiino/golang"
-	"github.com/jackc/pgconn"
-	"github.com/jackc/pgx/v4"
 )
 
-const (
-	PrepareUserStatement = `INSERT INTO userbase
-		(device_id, device_token, device_token_hashed, account_id, region, serial_number, points, og_title) 
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
-	SyncUserStatement = `SELECT 
-		account_id, device_token, serial_number
-	FROM userbase WHERE 
-		region = $1 AND
-		device_id = $2`
-	CheckUserStatement = `SELECT
-		1
-	FROM userbase WHERE
-		device_id = $1 AND
-		serial_number = $2 AND
-		region = $3`
-)
+// CheckRegistrationRequest describes the CheckRegistration action.
+type CheckRegistrationRequest struct {
+	SerialNumber string `xml:"SerialNumber" soap:"required"`
+}
 
-func checkRegistration(e *Envelope) {
-	serialNo, err := e.getKey("SerialNumber")
+func checkRegistration(e *Envelope, request *CheckRegistrationRequest) error {
+	serialNo := request.SerialNumber
+	registered, err := store.IsRegistered(e.DeviceId(), serialNo, e.Region())
 	if err != nil {
-		e.Error(5, "missing serial number", err)
-		return
+		log.Printf("error checking registration: %v\n", err)
+		return actionError(ErrorCodeServerError, "server-side error", err)
 	}
 
-	// We'll utilize our sync user statement.
-	query := pool.QueryRow(ctx, CheckUserStatement, e.DeviceId(), serialNo, e.Region())
-	err = query.Scan(nil)
-
 	// Formulate our response
 	e.AddKVNode("OriginalSerialNumber", serialNo)
 
-	if err != nil {
-		// We're either unregistered, or a database error occurred.
-		if err == pgx.ErrNoRows {
-			if serialNo == "LEH282082428" || serialNo == "LU306811256" {
-				e.AddKVNode("DeviceStatus", DeviceStatusRegistered)
-			} else {
-				e.AddKVNode("DeviceStatus", DeviceStatusUnregistered)
-			}
-		} else {
-			log.Printf("error executing statement: %v\n", err)
-			e.Error(5, "server-side error", err)
-		}
-	} else {
-		// No errors! We're safe.
+	if registered || serialNo == "LEH282082428" || serialNo == "LU306811256" {
 		e.AddKVNode("DeviceStatus", DeviceStatusRegistered)
+	} else {
+		e.AddKVNode("DeviceStatus", DeviceStatusUnregistered)
 	}
+
+	return nil
 }
 
-func getChallenge(e *Envelope) {
+func getChallenge(e *Envelope) error {
 	// The official Wii Shop Channel requests a Challenge from the server, and promptly disregards it.
 	// (Sometimes, it may not request a challenge at all.) No attempt is made to validate the response.
 	// It then uses another hard-coded value in place of this returned value entirely in any situation.
 	// For this reason, we consider it irrelevant.
 	e.AddKVNode("Challenge", SharedChallenge)
+
+	return nil
 }
 
-func getRegistrationInfo(e *Envelope) {
+func getRegistrationInfo(e *Envelope) error {
 	// GetRegistrationInfo is SyncRegistration with authentication and an additional key.
-	syncRegistration(e)
+	if err := syncRegistration(e); err != nil {
+		return err
+	}
 
 	// This _must_ be POINTS.
 	// It does not appear to be observed by any known client,
 	// but is sent by Nintendo in official requests.
 	e.AddKVNode("Currency", "POINTS")
-}
-
-func getWhitelistedSerialNumbers() []string {
-	file, err := os.Open("whitelist.txt")
-	if err != nil {
-		panic(err)
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-
-	// Read each line
-	var sns []string
-	for scanner.Scan() {
-		sns = append(sns, scanner.Text())
-
-	}
-
-	// Check for errors during scanning
-	if err = scanner.Err(); err != nil {
-		panic(err)
-	}
 
-	return sns
+	return nil
 }
 
-func syncRegistration(e *Envelope) {
-	var accountId int64
-	var deviceToken string
-	var serialNumber string
-
-	user := pool.QueryRow(ctx, SyncUserStatement, e.Region(), e.DeviceId())
-	err := user.Scan(&accountId, &deviceToken, &serialNumber)
+func syncRegistration(e *Envelope) error {
+	user, err := store.QueryUser(e.Region(), e.DeviceId())
 	if err != nil {
-		e.Error(107, "An error occurred querying the database.", err)
+		return actionError(IASDeviceNotRegistered, "An error occurred querying the database.", err)
+	} else if user == nil {
+		return actionError(IASDeviceNotRegistered, "An error occurred querying the database.", errors.New("device is not registered"))
 	}
 
-	if whitelistEnabled && !slices.Contains(getWhitelistedSerialNumbers(), serialNumber) {
-		// Since HTTP server runs on a separate Goroutine, this won't shut off the server,
-		// rather kill communication with the requesting console
-		panic(err)
+	if err = checkDeviceAccess(e.DeviceId(), user.SerialNumber); err != nil {
+		return actionError(IASDeviceNotPermitted, "device is not permitted", err)
 	}
 
-	e.AddKVNode("AccountId", strconv.FormatInt(accountId, 10))
-	e.AddKVNode("DeviceToken", deviceToken)
+	e.AddKVNode("AccountId", strconv.FormatInt(user.AccountId, 10))
+	e.AddKVNode("DeviceToken", user.DeviceToken)
 	e.AddKVNode("DeviceTokenExpired", "false")
 	e.AddKVNode("Country", e.Country())
 	e.AddKVNode("ExtAccountId", "")
 	e.AddKVNode("DeviceStatus", "R")
+
+	return nil
 }
 
-func register(e *Envelope) {
-	deviceCode, err := e.getKey("DeviceCode")
-	if err != nil {
-		e.Error(117, "missing device code", err)
-		return
-	}
+// RegisterRequest describes the Register action.
+// Its fields are validated by Validate, as each has an error code of its own.
+type RegisterRequest struct {
+	DeviceCode     string `xml:"DeviceCode"`
+	RegisterRegion string `xml:"RegisterRegion"`
+	SerialNumber   string `xml:"SerialNumber"`
+}
 
-	registerRegion, err := e.getKey("RegisterRegion")
-	if err != nil {
-		e.Error(127, "missing registration region", err)
-		return
+func (r *RegisterRequest) Validate() error {
+	if r.DeviceCode == "" {
+		return actionError(IASMissingDeviceCode, "missing device code", errors.New("missing mandatory key named DeviceCode"))
 	}
-	if registerRegion != e.Region() {
-		e.Error(137, "mismatched region", errors.New("region does not match registration region"))
-		return
+	if r.RegisterRegion == "" {
+		return actionError(IASMissingRegisterRegion, "missing registration region", errors.New("missing mandatory key named RegisterRegion"))
 	}
+	if r.SerialNumber == "" {
+		return actionError(IASMissingSerialNumber, "missing serial number", errors.New("missing mandatory key named SerialNumber"))
+	}
+	return nil
+}
 
-	serialNo, err := e.getKey("SerialNumber")
-	if err != nil {
-		e.Error(147, "missing serial number", err)
-		return
+func register(e *Envelope, request *RegisterRequest) error {
+	deviceCode := request.DeviceCode
+	if request.RegisterRegion != e.Region() {
+		return actionError(IASRegionMismatch, "mismatched region", errors.New("region does not match registration region"))
 	}
 
-	if whitelistEnabled && !slices.Contains(getWhitelistedSerialNumbers(), serialNo) {
-		// Since HTTP server runs on a separate Goroutine, this won't shut off the server,
-		// rather kill communication with the requesting console
-		panic(err)
+	serialNo := request.SerialNumber
+	err := checkDeviceAccess(e.DeviceId(), serialNo)
+	if err != nil {
+		return actionError(IASDeviceNotPermitted, "device is not permitted", err)
 	}
 
 	// Validate given friend code.
 	userId, err := strconv.ParseUint(deviceCode, 10, 64)
 	if err != nil {
-		e.Error(157, "invalid friend code", err)
-		return
+		return actionError(IASInvalidDeviceCode, "invalid friend code", err)
 	}
 	if wiino.NWC24CheckUserID(userId) != 0 {
-		e.Error(167, "invalid friend code", err)
-		return
+		return actionError(IASInvalidDeviceCode, "invalid friend code", err)
 	}
 
 	// Generate a random 9-digit number, padding zeros as necessary.
@@ -198,18 +150,21 @@ func register(e *Envelope) {
 	md5DeviceToken := fmt.Sprintf("%x", md5.Sum([]byte(deviceToken)))
 
 	// Insert all of our obtained values to the database...
-	_, err = pool.Exec(ctx, PrepareUserStatement, e.DeviceId(), deviceToken, md5DeviceToken, accountId, e.Region(), serialNo, "0", "WiiMart")
-	if err != nil {
-		// It's okay if this isn't a PostgreSQL error, as perhaps other issues have come in.
-		if driverErr, ok := err.(*pgconn.PgError); ok {
-			if driverErr.Code == "23505" {
-				e.Error(177, "database error", errors.New("user already exists"))
-				return
-			}
-		}
+	err = store.CreateUser(User{
+		AccountId:         accountId,
+		DeviceId:          e.DeviceId(),
+		DeviceToken:       deviceToken,
+		DeviceTokenHashed: md5DeviceToken,
+		Region:            e.Region(),
+		SerialNumber:      serialNo,
+		OriginalTitle:     "WiiMart",
+		DeviceCode:        deviceCode,
+	})
+	if err == ErrUserExists {
+		return actionError(IASAccountExists, "database error", err)
+	} else if err != nil {
 		log.Printf("error executing statement: %v\n", err)
-		e.Error(187, "database error: ", err)
-		return
+		return actionError(IASRegistrationFailed, "database error: ", err)
 	}
 
 	fmt.Println("The request is valid! Responding...")
@@ -221,8 +176,110 @@ func register(e *Envelope) {
 	// We send these back as-is regardless.
 	e.AddKVNode("ExtAccountId", "")
 	e.AddKVNode("DeviceCode", deviceCode)
+
+	return nil
 }
 
-func unregister(e *Envelope) {
-	// how abnormal... ;3
+// UnregisterPolicy determines what happens to an account once its console unregisters.
+type UnregisterPolicy string
+
+const (
+	// UnregisterArchive retains a copy of the account and its owned titles before removal.
+	UnregisterArchive UnregisterPolicy = "archive"
+	// UnregisterDelete removes the account and its owned titles entirely.
+	UnregisterDelete UnregisterPolicy = "delete"
+)
+
+func unregister(e *Envelope) error {
+	accountId, err := e.AccountId()
+	if err != nil {
+		return actionError(IASUnregisterFailed, "missing account ID", err)
+	}
+
+	// Points and transaction history are retained regardless, as they serve as our audit log.
+	err = store.RemoveUser(accountId, e.DeviceId(), unregisterPolicy == UnregisterArchive)
+	if err == ErrUnknownAccount {
+		return actionError(IASUnregisterFailed, "device is not registered to this account", err)
+	} else if err != nil {
+		log.Printf("error removing account: %v\n", err)
+		return actionError(IASUnregisterFailed, "database error", err)
+	}
+
+	e.AddKVNode("DeviceStatus", DeviceStatusUnregistered)
+
+	return nil
+}
+
+// MoveAccountRequest describes the MoveAccount action.
+// The target console must first register, and its account ID and device token are provided alongside it.
+type MoveAccountRequest struct {
+	TargetDeviceId     int    `xml:"TargetDeviceId" soap:"required"`
+	TargetSerialNumber string `xml:"TargetSerialNumber" soap:"required"`
+	TargetAccountId    int64  `xml:"TargetAccountId" soap:"required"`
+	TargetDeviceToken  string `xml:"TargetDeviceToken" soap:"required"`
+}
+
+// moveAccount transfers the authenticated account, alongside its points, owned titles and tickets,
+// to another console, such as when moving from a Wii to a vWii.
+// The target console's registration is replaced by the moved account.
+func moveAccount(e *Envelope, request *MoveAccountRequest) error {
+	accountId, err := e.AccountId()
+	if err != nil {
+		return actionError(IASMoveFailed, "missing account ID", err)
+	}
+
+	targetDeviceId := request.TargetDeviceId
+	if targetDeviceId == e.DeviceId() {
+		return actionError(IASMoveFailed, "account is already registered to this device", errors.New("target device matches source device"))
+	}
+
+	targetSerialNo := request.TargetSerialNumber
+	if err = checkDeviceAccess(targetDeviceId, targetSerialNo); err != nil {
+		return actionError(IASDeviceNotPermitted, "target device is not permitted", err)
+	}
+
+	// The target console proves it consents to this move with the token issued at its registration.
+	hash, tokenType := determineTokenFormat(request.TargetDeviceToken)
+	if tokenType == TokenTypeInvalid {
+		return actionError(IASMoveFailed, "invalid target device token", errors.New("target device token is malformed"))
+	}
+	valid, err := store.VerifyToken(request.TargetAccountId, targetDeviceId, hash, tokenType)
+	if err != nil {
+		log.Printf("error moving account: %v\n", err)
+		return actionError(IASMoveFailed, "database error", err)
+	} else if !valid {
+		return actionError(IASMoveFailed, "target device is not registered", errors.New("target device token does not match its registration"))
+	}
+
+	// Titles and points may not leave the region they were purchased within.
+	target, err := store.QueryUser(e.Region(), targetDeviceId)
+	if err != nil {
+		log.Printf("error moving account: %v\n", err)
+		return actionError(IASMoveFailed, "database error", err)
+	} else if target == nil {
+		return actionError(IASRegionMismatch, "mismatched region", errors.New("target device is registered within another region"))
+	}
+	if target.SerialNumber != targetSerialNo {
+		return actionError(IASMoveFailed, "target device is not registered", errors.New("target serial number does not match its registration"))
+	}
+
+	// Points and transactions are associated with the account, and follow it as-is.
+	err = store.MoveUser(accountId, e.DeviceId(), *target)
+	switch err {
+	case nil:
+	case ErrUnknownAccount:
+		return actionError(IASMoveFailed, "device is not registered to this account", err)
+	case ErrMigrateLimitReached:
+		return actionError(IASMigrateLimitReached, "migration limit reached", err)
+	case ErrDeviceExists:
+		return actionError(IASMoveFailed, "target device is registered to an account in use", err)
+	default:
+		log.Printf("error moving account: %v\n", err)
+		return actionError(IASMoveFailed, "database error", err)
+	}
+
+	e.AddKVNode("AccountId", strconv.FormatInt(accountId, 10))
+	e.AddKVNode("DeviceStatus", DeviceStatusRegistered)
+
+	return nil
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/wii-tools/wadlib"
+)
+
+// Kind returns the kind of this limit. Limits without a kind are permanent.
+func (l Limits) Kind() (LimitKinds, error) {
+	if l.LimitKind == "" {
+		return PR, nil
+	}
+
+	kind, err := GetLimitKind(l.LimitKind)
+	if err != nil {
+		return PR, err
+	}
+	return *kind, nil
+}
+
+// Limit codes within a ticket's time limit entries, as documented at https://wiibrew.org/wiki/Ticket.
+// ES enforces only these codes.
+const (
+	// ticketLimitPlayTime limits the amount of seconds a title may be played for.
+	ticketLimitPlayTime uint32 = 1
+	// ticketLimitLaunches limits the amount of times a title may be launched.
+	ticketLimitLaunches uint32 = 4
+)
+
+// applyLimits restricts the given ticket according to the given limits,
+// such as the amount of minutes a rental may be played for.
+func applyLimits(ticket *wadlib.Ticket, limits Limits) error {
+	kind, err := limits.Kind()
+	if err != nil {
+		return err
+	}
+	if kind == PR {
+		return nil
+	}
+
+	if limits.Limits <= 0 {
+		return fmt.Errorf("limit %s must have a positive value", limits.LimitKind)
+	}
+
+	var entry wadlib.TimeLimitEntry
+	switch kind {
+	case TR:
+		entry = wadlib.TimeLimitEntry{Code: ticketLimitPlayTime, Limit: uint32(limits.Limits) * 60}
+	case LR:
+		entry = wadlib.TimeLimitEntry{Code: ticketLimitLaunches, Limit: uint32(limits.Limits)}
+	default:
+		return fmt.Errorf("limit kind %s cannot be enforced by tickets", limits.LimitKind)
+	}
+
+	ticket.TimeLimits[0] = entry
+	return nil
+}
+
+// ticketLicence describes the access a ticket grants to its title.
+type ticketLicence struct {
+	// Limited is set if play time or launches are limited, such as for a rental.
+	Limited bool
+	// WholeTitle is set if every content within the title may be accessed.
+	WholeTitle bool
+}
+
+// licenceOf returns the access the given ticket grants.
+func licenceOf(ticket *wadlib.Ticket) ticketLicence {
+	return ticketLicence{
+		Limited:    ticket.TimeLimits != [8]wadlib.TimeLimitEntry{},
+		WholeTitle: permitsWholeTitle(ticket),
+	}
+}
+
+// covers determines whether this licence grants at least the access of another,
+// such that a ticket granting it may replace one granting the other.
+func (l ticketLicence) covers(other ticketLicence) bool {
+	return (!l.Limited || other.Limited) && (l.WholeTitle || !other.WholeTitle)
+}
+
+// issuedLicence returns the access granted by the ticket issued to the given account for a title,
+// or nil if none was issued.
+func issuedLicence(accountId int64, titleId string) (*ticketLicence, error) {
+	tickets, err := store.Tickets(accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stored := range tickets {
+		if stored.TitleId != titleId {
+			continue
+		}
+
+		var ticket wadlib.Ticket
+		err = binary.Read(bytes.NewReader(stored.Ticket), binary.BigEndian, &ticket)
+		if err != nil {
+			return nil, err
+		}
+		licence := licenceOf(&ticket)
+		return &licence, nil
+	}
+
+	return nil, nil
+}
+
+// selectPricing determines the price an item is being purchased at among those within our catalog.
+// The price must match the amount the console agreed to pay, and the limit kind it chose, if any.
+// Items without a matching price may not be purchased.
+func selectPricing(prices []Prices, amount int, limitKind string) (Prices, error) {
+	for _, price := range prices {
+		if price.Price.Amount != amount {
+			continue
+		}
+		if limitKind != "" && price.Limits.LimitKind != limitKind {
+			continue
+		}
+
+		return price, nil
+	}
+
+	if limitKind != "" {
+		return Prices{}, fmt.Errorf("item has no price of %d with limit kind %s", amount, limitKind)
+	}
+	return Prices{}, fmt.Errorf("item has no price of %d", amount)
+}
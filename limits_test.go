@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/wii-tools/wadlib"
+)
+
+func TestApplyLimits(t *testing.T) {
+	tests := []struct {
+		limits   Limits
+		expected wadlib.TimeLimitEntry
+	}{
+		{LimitStruct(PR), wadlib.TimeLimitEntry{}},
+		// An hour of play is limited in seconds.
+		{LimitValueStruct(TR, 60), wadlib.TimeLimitEntry{Code: 1, Limit: 3600}},
+		{LimitValueStruct(LR, 5), wadlib.TimeLimitEntry{Code: 4, Limit: 5}},
+	}
+
+	for _, test := range tests {
+		ticket, err := newTicket(testAccountId, testDeviceId, testAppTitleId, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = applyLimits(ticket, test.limits); err != nil {
+			t.Errorf("%s: %v", test.limits.LimitKind, err)
+			continue
+		}
+
+		// Limits must survive within the ticket as issued.
+		contents, err := encodeTicket(ticket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded wadlib.Ticket
+		err = binary.Read(bytes.NewReader(contents), binary.BigEndian, &decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := [8]wadlib.TimeLimitEntry{test.expected}
+		if decoded.TimeLimits != expected {
+			t.Errorf("%s: limited by %+v, expected %+v", test.limits.LimitKind, decoded.TimeLimits, expected)
+		}
+	}
+
+	// Tickets cannot enforce other kinds of limits.
+	for _, kind := range []LimitKinds{DR, SR, AT} {
+		var ticket wadlib.Ticket
+		if err := applyLimits(&ticket, LimitValueStruct(kind, 1)); err == nil {
+			t.Errorf("expected %s limits to be rejected", limitKindNames[kind])
+		}
+	}
+}
+
+func TestSelectPricing(t *testing.T) {
+	prices := []Prices{
+		{ItemId: 1, Price: Price{Amount: 500}, Limits: LimitStruct(PR), LicenseKind: PERMANENT},
+		{ItemId: 1, Price: Price{Amount: 100}, Limits: LimitValueStruct(TR, 60), LicenseKind: RENTAL},
+		{ItemId: 1, Price: Price{Amount: 0}, Limits: LimitValueStruct(LR, 5), LicenseKind: TRIAL},
+	}
+
+	tests := []struct {
+		amount    int
+		limitKind string
+		expected  LicenceKinds
+	}{
+		{500, "", PERMANENT},
+		{100, "", RENTAL},
+		{0, "LR", TRIAL},
+		{100, "TR", RENTAL},
+	}
+
+	for _, test := range tests {
+		pricing, err := selectPricing(prices, test.amount, test.limitKind)
+		if err != nil {
+			t.Errorf("%d %s: %v", test.amount, test.limitKind, err)
+		} else if pricing.LicenseKind != test.expected {
+			t.Errorf("%d %s: selected %s, expected %s", test.amount, test.limitKind, pricing.LicenseKind, test.expected)
+		}
+	}
+
+	rejected := []struct {
+		amount    int
+		limitKind string
+	}{
+		// Amounts not within our catalog.
+		{250, ""},
+		{1, ""},
+		// Limit kinds which the item does not offer, or at another amount.
+		{100, "DR"},
+		{0, "TR"},
+		{500, "TR"},
+		// A free trial does not permit a free permanent licence.
+		{0, "PR"},
+	}
+	for _, test := range rejected {
+		if pricing, err := selectPricing(prices, test.amount, test.limitKind); err == nil {
+			t.Errorf("%d %s: expected rejection, selected %s", test.amount, test.limitKind, pricing.LicenseKind)
+		}
+	}
+
+	// Items without any prices may not be purchased.
+	if _, err := selectPricing(nil, 0, ""); err == nil {
+		t.Error("expected an item without prices to be rejected")
+	}
+}
+
+func TestTicketLicenceCovers(t *testing.T) {
+	permanent := ticketLicence{WholeTitle: true}
+	rental := ticketLicence{Limited: true, WholeTitle: true}
+	contentSet := ticketLicence{}
+
+	tests := []struct {
+		licence  ticketLicence
+		existing ticketLicence
+		expected bool
+	}{
+		{permanent, permanent, true},
+		{permanent, rental, true},
+		{rental, rental, true},
+		// Renting a title owned permanently would take away its licence.
+		{rental, permanent, false},
+		{permanent, contentSet, true},
+		{contentSet, permanent, false},
+		{contentSet, rental, false},
+	}
+
+	for _, test := range tests {
+		if covers := test.licence.covers(test.existing); covers != test.expected {
+			t.Errorf("%+v covering %+v = %t, expected %t", test.licence, test.existing, covers, test.expected)
+		}
+	}
+}
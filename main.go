@@ -21,6 +21,7 @@ import (
 	"context"
 	crypto "crypto/rand"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"io/ioutil"
@@ -29,6 +30,8 @@ import (
 	"math/big"
 	"math/rand"
 	"net/http"
+	"os"
+	"time"
 )
 
 const (
@@ -43,6 +46,8 @@ var ctx = context.Background()
 var isDebug = false
 var ignoreAuth = false
 var whitelistEnabled = false
+var unregisterPolicy = UnregisterArchive
+var migrateLimit = defaultMigrateLimit
 
 // checkError makes error handling not as ugly and inefficient.
 func checkError(err error) {
@@ -51,6 +56,54 @@ func checkError(err error) {
 	}
 }
 
+// newRouter returns a Route with all actions we support registered.
+func newRouter() Route {
+	r := NewRoute()
+	ecs := r.HandleGroup("ecs")
+	{
+		ecs.Authenticated("CheckDeviceStatus", checkDeviceStatus)
+		ecs.Authenticated("NotifyETicketsSynced", notifyETicketsSynced)
+		ecs.Authenticated("ListETickets", listETickets)
+		ecs.Authenticated("GetETickets", handle(getETickets))
+		ecs.Authenticated("PurchaseTitle", handle(purchaseTitle))
+		ecs.Unauthenticated("GetECConfig", getECConfig)
+		ecs.Authenticated("ListPurchaseHistory", handle(listPurchaseHistory))
+		ecs.Authenticated("PurchasePoints", handle(purchasePoints))
+		ecs.Authenticated("CheckAccountBalance", checkAccountBalance)
+		ecs.Authenticated("GiftTitle", handle(giftTitle))
+		ecs.Authenticated("AcceptGiftTitle", handle(acceptGiftTitle))
+	}
+
+	ias := r.HandleGroup("ias")
+	{
+		ias.Unauthenticated("CheckRegistration", handle(checkRegistration))
+		ias.Unauthenticated("GetChallenge", getChallenge)
+		ias.Authenticated("GetRegistrationInfo", getRegistrationInfo)
+		ias.Unauthenticated("SyncRegistration", syncRegistration)
+		ias.Unauthenticated("Register", handle(register))
+		ias.Authenticated("Unregister", unregister)
+		ias.Authenticated("MoveAccount", handle(moveAccount))
+	}
+
+	cas := r.HandleGroup("cas")
+	{
+		cas.Authenticated("ListItems", handle(listItems))
+		cas.Authenticated("ListTitles", handle(listTitles))
+		cas.Authenticated("GetTitleDetails", handle(getTitleDetails))
+		cas.Authenticated("ListContentSets", handle(listContentSets))
+		cas.Authenticated("ListCategories", listCategories)
+	}
+
+	nus := r.HandleGroup("nus")
+	{
+		nus.Unauthenticated("GetSystemUpdate", getSystemUpdate)
+		nus.Unauthenticated("GetSystemTitleHash", getSystemTitleHash)
+		nus.Unauthenticated("GetSystemCommonETicket", handle(getSystemCommonETicket))
+	}
+
+	return r
+}
+
 func main() {
 	// Seed our random number generator before anything else.
 	seed, err := crypto.Int(crypto.Reader, big.NewInt(math.MaxInt64))
@@ -75,49 +128,125 @@ func main() {
 
 	whitelistEnabled = readConfig.Whitelist
 
-	// Start SQL.
-	dbString := fmt.Sprintf("postgres://%s:%s@%s/%s", readConfig.SQLUser, readConfig.SQLPass, readConfig.SQLAddress, readConfig.SQLDB)
-	dbConf, err := pgxpool.ParseConfig(dbString)
-	checkError(err)
-	pool, err = pgxpool.ConnectConfig(ctx, dbConf)
-	checkError(err)
+	switch readConfig.UnregisterPolicy {
+	case "":
+		// Archive accounts by default.
+	case UnregisterArchive, UnregisterDelete:
+		unregisterPolicy = readConfig.UnregisterPolicy
+	default:
+		log.Fatalf("Unknown UnregisterPolicy %q, expected %q or %q\n", readConfig.UnregisterPolicy, UnregisterArchive, UnregisterDelete)
+	}
 
-	// Ensure this PostgreSQL connection is valid.
-	defer pool.Close()
-	checkError(err)
+	if readConfig.MigrateLimit < 0 {
+		log.Fatalf("MigrateLimit must not be negative\n")
+	} else if readConfig.MigrateLimit > 0 {
+		migrateLimit = readConfig.MigrateLimit
+	}
 
-	baseUrl = readConfig.BaseURL
+	checkError(loadSystemTitles(readConfig.SystemTitles))
 
-	// Start the HTTP server.
-	fmt.Printf("Starting HTTP connection (%s)...\nNot using the usual port for HTTP?\nBe sure to use a proxy, otherwise the Wii can't connect!\n", readConfig.Address)
+	titleProvider, err = newTitleProvider(readConfig.TitleProvider, readConfig.TitleSource, readConfig.TitleCacheTTL)
+	checkError(err)
+	fmt.Printf("[i] Validating purchases against %s\n", titleProvider)
+
+	switch readConfig.Storage {
+	case "", StoragePostgres:
+		// Start SQL.
+		dbString := fmt.Sprintf("postgres://%s:%s@%s/%s", readConfig.SQLUser, readConfig.SQLPass, readConfig.SQLAddress, readConfig.SQLDB)
+		dbConf, err := pgxpool.ParseConfig(dbString)
+		checkError(err)
+		pool, err = pgxpool.ConnectConfig(ctx, dbConf)
+		checkError(err)
+
+		// Ensure this PostgreSQL connection is valid.
+		defer pool.Close()
+		checkError(err)
+
+		store = newPostgresStore(pool)
+	case StorageMemory:
+		fmt.Println("[!] Using in-memory storage. All accounts and purchases will be lost upon exit!")
+		memory := newMemoryStore()
+		store = memory
+
+		// Without a database, the catalog can only be loaded from a file.
+		if readConfig.MemoryCatalog != "" {
+			checkError(memory.loadCatalogFile(readConfig.MemoryCatalog))
+		} else {
+			fmt.Println("[!] No MemoryCatalog is configured, so no titles may be listed or purchased.")
+		}
+
+		// Without a database, only whitelist.txt can permit consoles.
+		if whitelistEnabled {
+			err = loadWhitelistFile()
+			if errors.Is(err, os.ErrNotExist) {
+				log.Fatalf("Whitelist requires whitelist.txt when using in-memory storage\n")
+			}
+			checkError(err)
+		}
+	default:
+		log.Fatalf("Unknown Storage %q, expected %q or %q\n", readConfig.Storage, StoragePostgres, StorageMemory)
+	}
 
-	r := NewRoute()
-	ecs := r.HandleGroup("ecs")
-	{
-		ecs.Authenticated("CheckDeviceStatus", checkDeviceStatus)
-		ecs.Authenticated("NotifyETicketsSynced", notifyETicketsSynced)
-		ecs.Authenticated("ListETickets", listETickets)
-		ecs.Authenticated("GetETickets", getETickets)
-		ecs.Authenticated("PurchaseTitle", purchaseTitle)
-		ecs.Unauthenticated("GetECConfig", getECConfig)
-		ecs.Authenticated("ListPurchaseHistory", listPurchaseHistory)
+	// Subcommands operate on the database directly, and exit once complete.
+	if len(os.Args) > 1 {
+		if pool == nil {
+			log.Fatalf("Commands require PostgreSQL storage\n")
+		}
+
+		// Other commands rely upon our schema being up to date, as serving does.
+		if os.Args[1] != "migrate" {
+			_, err = applyMigrations()
+			checkError(err)
+		}
+
+		switch os.Args[1] {
+		case "migrate":
+			runMigrate()
+		case "import-wad":
+			runImportWAD(os.Args[2:], readConfig.ContentRoot)
+		case "sync-catalog":
+			runSyncCatalog(os.Args[2:], readConfig.CatalogSource)
+		default:
+			log.Fatalf("Unknown command %q, expected \"migrate\", \"import-wad\" or \"sync-catalog\"\n", os.Args[1])
+		}
+		return
 	}
 
-	ias := r.HandleGroup("ias")
-	{
-		ias.Unauthenticated("CheckRegistration", checkRegistration)
-		ias.Unauthenticated("GetChallenge", getChallenge)
-		ias.Authenticated("GetRegistrationInfo", getRegistrationInfo)
-		ias.Unauthenticated("SyncRegistration", syncRegistration)
-		ias.Unauthenticated("Register", register)
-		ias.Authenticated("Unregister", unregister)
+	if pool != nil {
+		// Ensure our schema is up to date before serving.
+		_, err = applyMigrations()
+		checkError(err)
+
+		// Load which consoles may use the shop, keeping up to date with any changes.
+		if whitelistEnabled {
+			checkError(importWhitelistFile())
+		}
+		checkError(loadAccessList())
+		go watchAccessList()
+
+		// Keep our catalog in line with its feed, if desired.
+		if readConfig.CatalogSyncInterval != "" {
+			interval, err := time.ParseDuration(readConfig.CatalogSyncInterval)
+			checkError(err)
+			if interval <= 0 {
+				log.Fatalf("CatalogSyncInterval must be positive\n")
+			}
+			go watchCatalog(catalogSource(readConfig.CatalogSource), interval)
+		}
+	} else if readConfig.CatalogSyncInterval != "" {
+		fmt.Println("[!] CatalogSyncInterval requires PostgreSQL storage, and is ignored.")
 	}
 
-	cas := r.HandleGroup("cas")
-	{
-		cas.Authenticated("ListItems", listItems)
+	baseUrl = readConfig.BaseURL
+
+	// Start the HTTP server.
+	fmt.Printf("Starting HTTP connection (%s)...\nNot using the usual port for HTTP?\nBe sure to use a proxy, otherwise the Wii can't connect!\n", readConfig.Address)
+
+	r := newRouter()
+	if readConfig.ContentRoot != "" {
+		fmt.Printf("[i] Serving title contents from %s\n", readConfig.ContentRoot)
 	}
-	log.Fatal(http.ListenAndServe(readConfig.Address, r.Handle()))
+	log.Fatal(http.ListenAndServe(readConfig.Address, newServeMux(r, readConfig.ContentRoot)))
 
 	// From here on out, all special cool things should go into their respective handler function.
 }
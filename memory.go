@@ -0,0 +1,742 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore retains all state in memory. It is intended for development and testing,
+// and its contents are lost upon exit.
+type memoryStore struct {
+	mu sync.Mutex
+
+	users           map[int64]*User
+	archivedUsers   []User
+	owned           map[int64][]memoryOwnedTitle
+	ownedContents   map[int64]map[string][]int
+	archivedOwned   []memoryOwnedTitle
+	tickets         map[int64]map[string]*storedTicket
+	balances        map[int64]int
+	transactions    map[int64][]transactionRecord
+	gifts           map[memoryGiftKey]memoryGift
+	subscriptions   map[int64]map[int]*subscription
+	migrations      map[int64]int
+	nextTransaction int64
+
+	items       map[int]*memoryItem
+	titles      map[string]*TitleInfo
+	categories  map[string]Category
+	contentSets map[string][]ContentSet
+	plans       map[string]subscriptionPlan
+}
+
+// memoryOwnedTitle describes a single purchase of an item.
+type memoryOwnedTitle struct {
+	AccountId     int64
+	TitleId       string
+	Version       int
+	ItemId        int
+	DatePurchased time.Time
+}
+
+// memoryGiftKey identifies a gift pending acceptance.
+type memoryGiftKey struct {
+	TitleId       string
+	TransactionId string
+}
+
+// memoryGift describes a gift pending acceptance by its recipient.
+type memoryGift struct {
+	ItemId              int
+	SenderFriendCode    string
+	RecipientFriendCode string
+}
+
+// memoryItem describes an item within our catalog.
+type memoryItem struct {
+	ItemId      int
+	TitleId     string
+	ReferenceId string
+	// ContentSetId is the content set this item grants, or zero if it grants its title as a whole.
+	ContentSetId int
+	Prices       []memoryPrice
+}
+
+// memoryPrice describes a single price an item may be purchased for.
+type memoryPrice struct {
+	PricingCode int
+	Price       Prices
+}
+
+// newMemoryStore returns an empty Store retained in memory.
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		users:         map[int64]*User{},
+		owned:         map[int64][]memoryOwnedTitle{},
+		ownedContents: map[int64]map[string][]int{},
+		tickets:       map[int64]map[string]*storedTicket{},
+		balances:      map[int64]int{},
+		transactions:  map[int64][]transactionRecord{},
+		gifts:         map[memoryGiftKey]memoryGift{},
+		subscriptions: map[int64]map[int]*subscription{},
+		migrations:    map[int64]int{},
+		// Mirror the starting value of our PostgreSQL sequence.
+		nextTransaction: 10000000,
+
+		items:       map[int]*memoryItem{},
+		titles:      map[string]*TitleInfo{},
+		categories:  map[string]Category{},
+		contentSets: map[string][]ContentSet{},
+		plans:       map[string]subscriptionPlan{},
+	}
+}
+
+// page returns the given range of values, as described by ListResultOffset and ListResultSize.
+func page[T any](values []T, offset int, size int) []T {
+	if offset >= len(values) {
+		return nil
+	}
+
+	values = values[offset:]
+	if size < len(values) {
+		values = values[:size]
+	}
+	return values
+}
+
+// userByDevice returns the account registered to the given device, or nil if there is none.
+// The store must be locked.
+func (s *memoryStore) userByDevice(deviceId int) *User {
+	for _, user := range s.users {
+		if user.DeviceId == deviceId {
+			return user
+		}
+	}
+	return nil
+}
+
+// deviceUser returns the given account if it is registered to the given device.
+// The store must be locked.
+func (s *memoryStore) deviceUser(accountId int64, deviceId int) (*User, error) {
+	user, ok := s.users[accountId]
+	if !ok || user.DeviceId != deviceId {
+		return nil, ErrUnknownAccount
+	}
+	return user, nil
+}
+
+// chargeable ensures the given account exists and may be charged the given amount.
+// The store must be locked.
+func (s *memoryStore) chargeable(accountId int64, amount int) error {
+	if _, ok := s.users[accountId]; !ok {
+		return ErrUnknownAccount
+	}
+	if s.balances[accountId]-amount < 0 {
+		return ErrInsufficientPoints
+	}
+	return nil
+}
+
+// recordTransaction stores the given transaction, assigning an ID and defaults as necessary.
+// The store must be locked.
+func (s *memoryStore) recordTransaction(accountId int64, record *transactionRecord) {
+	if record.TransactionId == 0 {
+		record.TransactionId = s.reserveTransactionId()
+	}
+	if record.Date.IsZero() {
+		record.Date = time.Now().UTC()
+	}
+	if record.Currency == "" {
+		record.Currency = "POINTS"
+	}
+
+	s.transactions[accountId] = append(s.transactions[accountId], *record)
+}
+
+// reserveTransactionId returns a new, unique transaction ID. The store must be locked.
+func (s *memoryStore) reserveTransactionId() int64 {
+	transactionId := s.nextTransaction
+	s.nextTransaction++
+	return transactionId
+}
+
+// issueTicket returns the ticket previously issued for the granted title,
+// or stores and returns the granted ticket if none was. The store must be locked.
+func (s *memoryStore) issueTicket(accountId int64, grant ticketGrant) []byte {
+	tickets, ok := s.tickets[accountId]
+	if !ok {
+		tickets = map[string]*storedTicket{}
+		s.tickets[accountId] = tickets
+	}
+
+	if stored, ok := tickets[grant.TitleId]; ok {
+		if !grant.Replace {
+			return stored.Ticket
+		}
+
+		stored.Ticket = grant.Ticket
+		return grant.Ticket
+	}
+
+	tickets[grant.TitleId] = &storedTicket{
+		TitleId:      grant.TitleId,
+		Ticket:       grant.Ticket,
+		MigrateLimit: migrateLimit,
+	}
+	return grant.Ticket
+}
+
+// associateTitle grants the given item to an account, replacing any prior purchase of it.
+// The store must be locked.
+func (s *memoryStore) associateTitle(title memoryOwnedTitle) {
+	owned := s.owned[title.AccountId]
+	for i, current := range owned {
+		if current.ItemId == title.ItemId && title.ItemId != 0 {
+			owned[i] = title
+			return
+		}
+	}
+
+	s.owned[title.AccountId] = append(owned, title)
+}
+
+func (s *memoryStore) CreateUser(user User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[user.AccountId]; ok {
+		return ErrUserExists
+	}
+	for _, existing := range s.users {
+		if existing.DeviceId == user.DeviceId || existing.DeviceToken == user.DeviceToken {
+			return ErrUserExists
+		}
+	}
+
+	s.users[user.AccountId] = &user
+	return nil
+}
+
+func (s *memoryStore) QueryUser(region string, deviceId int) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.userByDevice(deviceId)
+	if user == nil || user.Region != region {
+		return nil, nil
+	}
+
+	copied := *user
+	return &copied, nil
+}
+
+func (s *memoryStore) IsRegistered(deviceId int, serialNumber string, region string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user := s.userByDevice(deviceId)
+	return user != nil && user.SerialNumber == serialNumber && user.Region == region, nil
+}
+
+func (s *memoryStore) VerifyToken(accountId int64, deviceId int, token string, tokenType TokenType) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.deviceUser(accountId, deviceId)
+	if err != nil {
+		return false, nil
+	}
+
+	if tokenType == TokenTypeHashed {
+		return user.DeviceTokenHashed == token, nil
+	}
+	return user.DeviceToken == token, nil
+}
+
+func (s *memoryStore) RemoveUser(accountId int64, deviceId int, archive bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.deviceUser(accountId, deviceId)
+	if err != nil {
+		return err
+	}
+
+	if archive {
+		s.archivedUsers = append(s.archivedUsers, *user)
+		s.archivedOwned = append(s.archivedOwned, s.owned[accountId]...)
+	}
+
+	// Points and transaction history are retained.
+	delete(s.tickets, accountId)
+	delete(s.ownedContents, accountId)
+	delete(s.owned, accountId)
+	delete(s.migrations, accountId)
+	delete(s.users, accountId)
+	return nil
+}
+
+func (s *memoryStore) MoveUser(accountId int64, deviceId int, target User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.deviceUser(accountId, deviceId)
+	if err != nil {
+		return err
+	}
+
+	// The target account was only registered to permit this move, and must not have been used.
+	if _, err = s.deviceUser(target.AccountId, target.DeviceId); err != nil {
+		return err
+	}
+	if len(s.owned[target.AccountId]) > 0 || len(s.transactions[target.AccountId]) > 0 {
+		return ErrDeviceExists
+	}
+	targetDeviceId := target.DeviceId
+
+	// The account itself is subject to the limit, as subscriptions and gifts move with it.
+	if s.migrations[accountId] >= migrateLimit {
+		return ErrMigrateLimitReached
+	}
+
+	// Ensure all tickets can be moved prior to changing any.
+	rebound := map[string][]byte{}
+	for titleId, ticket := range s.tickets[accountId] {
+		if ticket.MigrateCount >= ticket.MigrateLimit {
+			return ErrMigrateLimitReached
+		}
+
+		rebound[titleId], err = rebindTicket(ticket.Ticket, targetDeviceId)
+		if err != nil {
+			return err
+		}
+	}
+
+	for titleId, ticket := range s.tickets[accountId] {
+		ticket.Ticket = rebound[titleId]
+		ticket.MigrateCount++
+	}
+	// Gifts pending acceptance by this console follow the account.
+	for key, pending := range s.gifts {
+		if user.DeviceCode != "" && pending.RecipientFriendCode == user.DeviceCode {
+			pending.RecipientFriendCode = target.DeviceCode
+			s.gifts[key] = pending
+		}
+	}
+	s.migrations[accountId]++
+	delete(s.migrations, target.AccountId)
+	delete(s.tickets, target.AccountId)
+	delete(s.users, target.AccountId)
+	user.DeviceId = targetDeviceId
+	user.SerialNumber = target.SerialNumber
+	user.DeviceCode = target.DeviceCode
+	return nil
+}
+
+func (s *memoryStore) OwnedTitles(accountId int64) ([]ownedTitle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := map[string]int{}
+	for _, title := range s.owned[accountId] {
+		if version, ok := versions[title.TitleId]; !ok || title.Version > version {
+			versions[title.TitleId] = title.Version
+		}
+	}
+
+	var titles []ownedTitle
+	for titleId, version := range versions {
+		titles = append(titles, ownedTitle{
+			TitleId: titleId,
+			Version: version,
+		})
+	}
+	sort.Slice(titles, func(i, j int) bool {
+		return titles[i].TitleId < titles[j].TitleId
+	})
+	return titles, nil
+}
+
+func (s *memoryStore) OwnedContents(accountId int64, titleId string) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]int(nil), s.ownedContents[accountId][titleId]...), nil
+}
+
+func (s *memoryStore) Subscriptions(accountId int64, titleId string) ([]subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var subscriptions []subscription
+	for _, current := range s.subscriptions[accountId] {
+		if current.TitleId == titleId {
+			subscriptions = append(subscriptions, *current)
+		}
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].ItemId < subscriptions[j].ItemId
+	})
+
+	return subscriptions, nil
+}
+
+func (s *memoryStore) Tickets(accountId int64) ([]storedTicket, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var tickets []storedTicket
+	for _, ticket := range s.tickets[accountId] {
+		tickets = append(tickets, *ticket)
+	}
+	sort.Slice(tickets, func(i, j int) bool {
+		return tickets[i].TitleId < tickets[j].TitleId
+	})
+	return tickets, nil
+}
+
+func (s *memoryStore) IssueTicket(accountId int64, grant ticketGrant) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.issueTicket(accountId, grant), nil
+}
+
+func (s *memoryStore) Balance(accountId int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.balances[accountId], nil
+}
+
+func (s *memoryStore) Transactions(accountId int64, titleId string, offset int, size int) ([]transactionRecord, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var records []transactionRecord
+	for _, record := range s.transactions[accountId] {
+		if titleId != "" && record.TitleId != titleId {
+			continue
+		}
+		if current, ok := s.subscriptions[accountId][record.ItemId]; ok && !current.Active(now) {
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	// Newest first, as with PostgreSQL.
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].Date.Equal(records[j].Date) {
+			return records[i].Date.After(records[j].Date)
+		}
+		return records[i].TransactionId > records[j].TransactionId
+	})
+
+	return page(records, offset, size), len(records), nil
+}
+
+func (s *memoryStore) PurchaseTitle(accountId int64, record *transactionRecord, version int, grant ticketGrant, renewed *subscription) (int, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.chargeable(accountId, record.TotalPaid)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	s.recordTransaction(accountId, record)
+	s.balances[accountId] -= record.TotalPaid
+	ticket := s.issueTicket(accountId, grant)
+	if renewed != nil {
+		if s.subscriptions[accountId] == nil {
+			s.subscriptions[accountId] = map[int]*subscription{}
+		}
+		stored := *renewed
+		s.subscriptions[accountId][renewed.ItemId] = &stored
+	}
+	if grant.Contents != nil {
+		if s.ownedContents[accountId] == nil {
+			s.ownedContents[accountId] = map[string][]int{}
+		}
+		owned := s.ownedContents[accountId][grant.TitleId]
+		s.ownedContents[accountId][grant.TitleId] = mergeContents(owned, grant.Contents)
+	}
+	s.associateTitle(memoryOwnedTitle{
+		AccountId:     accountId,
+		TitleId:       record.TitleId,
+		Version:       version,
+		ItemId:        record.ItemId,
+		DatePurchased: record.Date,
+	})
+
+	return s.balances[accountId], ticket, nil
+}
+
+func (s *memoryStore) PurchasePoints(accountId int64, record *transactionRecord, points int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.chargeable(accountId, -points)
+	if err != nil {
+		return 0, err
+	}
+
+	s.recordTransaction(accountId, record)
+	s.balances[accountId] += points
+	return s.balances[accountId], nil
+}
+
+func (s *memoryStore) GiftTitle(accountId int64, record *transactionRecord, senderFriendCode string, recipientFriendCode string) (int, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.chargeable(accountId, record.TotalPaid)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	s.recordTransaction(accountId, record)
+
+	// The recipient's transaction is recorded once they accept the gift.
+	recipientTransactionId := s.reserveTransactionId()
+	s.balances[accountId] -= record.TotalPaid
+	s.gifts[memoryGiftKey{
+		TitleId:       record.TitleId,
+		TransactionId: formatTransactionId(recipientTransactionId),
+	}] = memoryGift{
+		ItemId:              record.ItemId,
+		SenderFriendCode:    senderFriendCode,
+		RecipientFriendCode: recipientFriendCode,
+	}
+
+	return s.balances[accountId], recipientTransactionId, nil
+}
+
+func (s *memoryStore) AcceptGift(accountId int64, transactionId int64, version int, grant ticketGrant) (*gift, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := memoryGiftKey{
+		TitleId:       grant.TitleId,
+		TransactionId: formatTransactionId(transactionId),
+	}
+	pending, ok := s.gifts[key]
+	if !ok {
+		return nil, nil, ErrGiftNotFound
+	}
+
+	// The gift must be addressed to this account's console, and be of an item we sell.
+	user, ok := s.users[accountId]
+	if !ok || user.DeviceCode == "" || user.DeviceCode != pending.RecipientFriendCode {
+		return nil, nil, ErrGiftNotFound
+	}
+	item, ok := s.items[pending.ItemId]
+	if !ok || !strings.EqualFold(item.TitleId, grant.TitleId) {
+		return nil, nil, ErrGiftNotFound
+	}
+
+	record := transactionRecord{
+		TransactionId: transactionId,
+		Type:          TransactionGiftReceived,
+		TitleId:       grant.TitleId,
+		ItemId:        item.ItemId,
+	}
+	s.recordTransaction(accountId, &record)
+	s.associateTitle(memoryOwnedTitle{
+		AccountId:     accountId,
+		TitleId:       grant.TitleId,
+		Version:       version,
+		ItemId:        item.ItemId,
+		DatePurchased: record.Date,
+	})
+	delete(s.gifts, key)
+	ticket := s.issueTicket(accountId, grant)
+
+	return &gift{
+		TitleId:          grant.TitleId,
+		ItemId:           item.ItemId,
+		SenderFriendCode: pending.SenderFriendCode,
+	}, ticket, nil
+}
+
+// sortedItems returns all catalog items, ordered by item ID. The store must be locked.
+func (s *memoryStore) sortedItems() []*memoryItem {
+	var items []*memoryItem
+	for _, item := range s.items {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ItemId < items[j].ItemId
+	})
+	return items
+}
+
+// sortedTitles returns all catalog titles, ordered by title ID. The store must be locked.
+func (s *memoryStore) sortedTitles() []*TitleInfo {
+	var titles []*TitleInfo
+	for _, title := range s.titles {
+		titles = append(titles, title)
+	}
+	sort.Slice(titles, func(i, j int) bool {
+		return titles[i].TitleId < titles[j].TitleId
+	})
+	return titles
+}
+
+func (s *memoryStore) CatalogItems(filter catalogFilter) ([]catalogItem, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var items []catalogItem
+	for _, item := range s.sortedItems() {
+		if filter.TitleId != "" && item.TitleId != filter.TitleId {
+			continue
+		}
+
+		var prices []Prices
+		for _, price := range item.Prices {
+			if filter.LicenseKind != "" && price.Price.LicenseKind != filter.LicenseKind {
+				continue
+			}
+			if filter.PricingCode != 0 && price.PricingCode != filter.PricingCode {
+				continue
+			}
+			prices = append(prices, price.Price)
+		}
+		if len(prices) == 0 {
+			continue
+		}
+
+		current := catalogItem{
+			ItemId:         item.ItemId,
+			TitleId:        item.TitleId,
+			ContentIndexes: s.itemContents(item),
+			Prices:         prices,
+		}
+		if title, ok := s.titles[item.TitleId]; ok {
+			current.TitleVersion = title.TitleVersion
+			current.Ratings = title.Ratings
+		}
+		items = append(items, current)
+	}
+
+	return page(items, filter.Offset, filter.Size), len(items), nil
+}
+
+func (s *memoryStore) CatalogTitles(category string, platform string, offset int, size int) ([]TitleInfo, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var titles []TitleInfo
+	for _, title := range s.sortedTitles() {
+		if platform != "" && title.Platform != platform {
+			continue
+		}
+
+		matches := category == ""
+		for _, current := range title.Categories {
+			matches = matches || current.CategoryCode == category
+		}
+		if !matches {
+			continue
+		}
+
+		// Listings only contain a summary of each title.
+		titles = append(titles, TitleInfo{
+			TitleId:      title.TitleId,
+			TitleName:    title.TitleName,
+			Platform:     title.Platform,
+			Publisher:    title.Publisher,
+			TitleVersion: title.TitleVersion,
+		})
+	}
+
+	return page(titles, offset, size), len(titles), nil
+}
+
+func (s *memoryStore) CatalogItem(itemId int) (*catalogItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[itemId]
+	if !ok {
+		return nil, nil
+	}
+
+	current := catalogItem{
+		ItemId:         item.ItemId,
+		TitleId:        item.TitleId,
+		ReferenceId:    item.ReferenceId,
+		ContentIndexes: s.itemContents(item),
+	}
+	for _, price := range item.Prices {
+		current.Prices = append(current.Prices, price.Price)
+	}
+	if title, ok := s.titles[item.TitleId]; ok {
+		current.TitleVersion = title.TitleVersion
+	}
+	return &current, nil
+}
+
+// itemContents returns the content indexes the given item grants, or nil if it grants its title as a whole.
+func (s *memoryStore) itemContents(item *memoryItem) []int {
+	if item.ContentSetId == 0 {
+		return nil
+	}
+
+	for _, set := range s.contentSets[item.TitleId] {
+		if set.ContentSetId == item.ContentSetId {
+			return set.ContentIndexes
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) CatalogTitle(titleId string) (*TitleInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	title, ok := s.titles[titleId]
+	if !ok {
+		return nil, nil
+	}
+
+	copied := *title
+	return &copied, nil
+}
+
+func (s *memoryStore) Categories() ([]Category, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var categories []Category
+	for _, category := range s.categories {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		return categories[i].CategoryCode < categories[j].CategoryCode
+	})
+	return categories, nil
+}
+
+func (s *memoryStore) ContentSets(titleId string) ([]ContentSet, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.contentSets[titleId], nil
+}
+
+func (s *memoryStore) SubscriptionPlan(titleId string) (*subscriptionPlan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	plan, ok := s.plans[titleId]
+	if !ok {
+		return nil, nil
+	}
+	return &plan, nil
+}
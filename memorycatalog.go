@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// memoryCatalog describes the catalog in-memory storage is populated with,
+// as read from the JSON file named by MemoryCatalog.
+type memoryCatalog struct {
+	Categories []memoryCatalogCategory `json:"categories"`
+	Titles     []memoryCatalogTitle    `json:"titles"`
+	Items      []memoryCatalogItem     `json:"items"`
+}
+
+// memoryCatalogCategory describes a category titles may be listed within.
+type memoryCatalogCategory struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// memoryCatalogTitle describes a title within the catalog file.
+type memoryCatalogTitle struct {
+	TitleId   string `json:"title_id"`
+	Name      string `json:"name"`
+	Platform  string `json:"platform"`
+	Publisher string `json:"publisher"`
+	Version   int    `json:"version"`
+	Size      int64  `json:"size"`
+	// Categories lists the codes of the categories this title is listed within.
+	Categories  []string                  `json:"categories"`
+	Ratings     []memoryCatalogRating     `json:"ratings"`
+	ContentSets []memoryCatalogContentSet `json:"content_sets"`
+	// Subscription is only declared by service titles, such as Wii no Ma's theatre.
+	Subscription *memoryCatalogSubscription `json:"subscription"`
+}
+
+// memoryCatalogRating describes an age rating given to a title.
+type memoryCatalogRating struct {
+	Name   string `json:"name"`
+	Rating int    `json:"rating"`
+	Age    int    `json:"age"`
+}
+
+// memoryCatalogContentSet describes a set of contents within a title that may be purchased alone.
+type memoryCatalogContentSet struct {
+	ContentSetId   int    `json:"content_set_id"`
+	Name           string `json:"name"`
+	ContentIndexes []int  `json:"content_indexes"`
+}
+
+// memoryCatalogSubscription describes the subscription a service title declares.
+type memoryCatalogSubscription struct {
+	DurationDays int                 `json:"duration_days"`
+	Renewal      SubscriptionRenewal `json:"renewal"`
+}
+
+// memoryCatalogItem describes an item within the catalog file.
+type memoryCatalogItem struct {
+	ItemId      int    `json:"item_id"`
+	TitleId     string `json:"title_id"`
+	ReferenceId string `json:"reference_id"`
+	// ContentSetId is the content set this item grants, or zero if it grants its title as a whole.
+	ContentSetId int                  `json:"content_set_id"`
+	Prices       []memoryCatalogPrice `json:"prices"`
+}
+
+// memoryCatalogPrice describes a single price an item may be purchased for.
+// Limits are as our catalog_prices table permits: "PR" without a value, or "TR" and "LR" with one.
+type memoryCatalogPrice struct {
+	PricingCode int          `json:"pricing_code"`
+	Amount      int          `json:"amount"`
+	LicenseKind LicenceKinds `json:"license_kind"`
+	LimitKind   string       `json:"limit_kind"`
+	LimitValue  int          `json:"limit_value"`
+}
+
+// loadCatalogFile populates the catalog of this store with that described by the given JSON file,
+// replacing any previously loaded.
+func (s *memoryStore) loadCatalogFile(path string) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var catalog memoryCatalog
+	err = json.Unmarshal(contents, &catalog)
+	if err != nil {
+		return fmt.Errorf("invalid catalog %s: %w", path, err)
+	}
+
+	return s.loadCatalog(catalog)
+}
+
+// loadCatalog populates the catalog of this store with the given catalog, replacing any previously loaded.
+// The catalog is validated as our schema would, and nothing is replaced should it be invalid.
+func (s *memoryStore) loadCatalog(catalog memoryCatalog) error {
+	categories := map[string]Category{}
+	for _, category := range catalog.Categories {
+		if category.Code == "" {
+			return fmt.Errorf("category %q has no code", category.Name)
+		}
+		categories[category.Code] = Category{CategoryCode: category.Code, CategoryName: category.Name}
+	}
+
+	titles := map[string]*TitleInfo{}
+	contentSets := map[string][]ContentSet{}
+	plans := map[string]subscriptionPlan{}
+	for _, title := range catalog.Titles {
+		if _, exists := titles[title.TitleId]; exists {
+			return fmt.Errorf("title %s is listed more than once", title.TitleId)
+		}
+		if _, err := newTicket(0, 0, title.TitleId, title.Version); err != nil {
+			return fmt.Errorf("title %s: %w", title.TitleId, err)
+		}
+
+		info := &TitleInfo{
+			TitleId:      title.TitleId,
+			TitleName:    title.Name,
+			Platform:     title.Platform,
+			Publisher:    title.Publisher,
+			TitleVersion: title.Version,
+			TitleSize:    title.Size,
+		}
+		for _, code := range title.Categories {
+			category, ok := categories[code]
+			if !ok {
+				return fmt.Errorf("title %s lists unknown category %q", title.TitleId, code)
+			}
+			info.Categories = append(info.Categories, category)
+		}
+		for _, rating := range title.Ratings {
+			info.Ratings = append(info.Ratings, Ratings{Name: rating.Name, Rating: rating.Rating, Age: rating.Age})
+		}
+		titles[title.TitleId] = info
+
+		for _, set := range title.ContentSets {
+			if set.ContentSetId <= 0 {
+				return fmt.Errorf("title %s lists content set %d, which must be positive", title.TitleId, set.ContentSetId)
+			}
+			contentSets[title.TitleId] = append(contentSets[title.TitleId], ContentSet{
+				ContentSetId:   set.ContentSetId,
+				Name:           set.Name,
+				ContentIndexes: set.ContentIndexes,
+			})
+		}
+
+		if title.Subscription != nil {
+			plan := title.Subscription
+			if plan.DurationDays <= 0 {
+				return fmt.Errorf("title %s declares a subscription without a duration", title.TitleId)
+			}
+			if plan.Renewal != RenewExtend && plan.Renewal != RenewReset {
+				return fmt.Errorf("title %s declares unknown renewal %q", title.TitleId, plan.Renewal)
+			}
+			plans[title.TitleId] = subscriptionPlan{DurationDays: plan.DurationDays, Renewal: plan.Renewal}
+		}
+	}
+
+	items := map[int]*memoryItem{}
+	for _, item := range catalog.Items {
+		if item.ItemId <= 0 {
+			return fmt.Errorf("item %d must be positive", item.ItemId)
+		}
+		if _, exists := items[item.ItemId]; exists {
+			return fmt.Errorf("item %d is listed more than once", item.ItemId)
+		}
+		if _, ok := titles[item.TitleId]; !ok {
+			return fmt.Errorf("item %d grants unknown title %s", item.ItemId, item.TitleId)
+		}
+		if item.ContentSetId != 0 && !hasContentSet(contentSets[item.TitleId], item.ContentSetId) {
+			return fmt.Errorf("item %d grants unknown content set %d", item.ItemId, item.ContentSetId)
+		}
+
+		current := &memoryItem{
+			ItemId:       item.ItemId,
+			TitleId:      item.TitleId,
+			ReferenceId:  strings.TrimSpace(item.ReferenceId),
+			ContentSetId: item.ContentSetId,
+		}
+		for _, price := range item.Prices {
+			parsed, err := price.parse(item.ItemId)
+			if err != nil {
+				return fmt.Errorf("item %d: %w", item.ItemId, err)
+			}
+			current.Prices = append(current.Prices, parsed)
+		}
+		items[item.ItemId] = current
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.categories = categories
+	s.titles = titles
+	s.contentSets = contentSets
+	s.plans = plans
+	s.items = items
+	return nil
+}
+
+// parse validates this price, returning it as listed for the given item.
+func (p memoryCatalogPrice) parse(itemId int) (memoryPrice, error) {
+	if p.Amount < 0 {
+		return memoryPrice{}, fmt.Errorf("price %d must not be negative", p.Amount)
+	}
+	if _, err := GetLicenceKind(string(p.LicenseKind)); err != nil {
+		return memoryPrice{}, fmt.Errorf("%w %q", err, p.LicenseKind)
+	}
+
+	limitKind := p.LimitKind
+	if limitKind == "" {
+		limitKind = limitKindNames[PR]
+	}
+	limits := Limits{Limits: p.LimitValue, LimitKind: limitKind}
+	kind, err := limits.Kind()
+	if err != nil {
+		return memoryPrice{}, fmt.Errorf("%w %q", err, p.LimitKind)
+	}
+	switch {
+	case kind == PR && p.LimitValue != 0:
+		return memoryPrice{}, fmt.Errorf("permanent price must not have a limit value")
+	case (kind == TR || kind == LR) && p.LimitValue <= 0:
+		return memoryPrice{}, fmt.Errorf("%s price must have a positive limit value", limitKind)
+	case kind != PR && kind != TR && kind != LR:
+		return memoryPrice{}, fmt.Errorf("%s limits cannot be applied to tickets", limitKind)
+	}
+
+	pricingCode := p.PricingCode
+	if pricingCode == 0 {
+		pricingCode = importPricingCode
+	}
+
+	return memoryPrice{
+		PricingCode: pricingCode,
+		Price: Prices{
+			ItemId:      itemId,
+			Price:       Price{Amount: p.Amount, Currency: "POINTS"},
+			Limits:      limits,
+			LicenseKind: p.LicenseKind,
+		},
+	}, nil
+}
+
+// hasContentSet determines whether the given content sets include that of the given ID.
+func hasContentSet(sets []ContentSet, contentSetId int) bool {
+	for _, set := range sets {
+		if set.ContentSetId == contentSetId {
+			return true
+		}
+	}
+	return false
+}
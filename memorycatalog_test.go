@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLoadCatalogFile(t *testing.T) {
+	s := newMemoryStore()
+	err := s.loadCatalogFile("testdata/memory_catalog.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, total, err := s.CatalogItems(catalogFilter{Size: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 4 || len(items) != 4 {
+		t.Fatalf("listed %d of %d items, expected 4", len(items), total)
+	}
+
+	rental, err := s.CatalogItem(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rental.TitleVersion != 2 || len(rental.Prices) != 2 || rental.Prices[1].Limits != LimitValueStruct(TR, 60) {
+		t.Errorf("item 1 loaded as %+v", rental)
+	}
+
+	stagePack, err := s.CatalogItem(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stagePack.ContentIndexes, []int{3}) {
+		t.Errorf("item 3 grants contents %v, expected [3]", stagePack.ContentIndexes)
+	}
+
+	theatre, err := s.CatalogItem(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := s.SubscriptionPlan(WiinoMaServiceTitleID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if theatre.ReferenceId != "0123456789ABCDEF0123456789ABCDEF" || plan == nil || plan.DurationDays != 30 {
+		t.Errorf("Wii no Ma loaded as %+v with plan %+v", theatre, plan)
+	}
+}
+
+func TestLoadCatalogInvalid(t *testing.T) {
+	title := memoryCatalogTitle{TitleId: testAppTitleId, Name: "Homebrew Browser", Platform: "WII"}
+	tests := []struct {
+		name    string
+		catalog memoryCatalog
+	}{
+		{"unknown title", memoryCatalog{Items: []memoryCatalogItem{{ItemId: 1, TitleId: testAppTitleId}}}},
+		{"unknown category", memoryCatalog{Titles: []memoryCatalogTitle{{TitleId: testAppTitleId, Categories: []string{"01"}}}}},
+		{"unknown content set", memoryCatalog{
+			Titles: []memoryCatalogTitle{title},
+			Items:  []memoryCatalogItem{{ItemId: 1, TitleId: testAppTitleId, ContentSetId: 1}},
+		}},
+		{"permanent limit value", memoryCatalog{
+			Titles: []memoryCatalogTitle{title},
+			Items: []memoryCatalogItem{{ItemId: 1, TitleId: testAppTitleId, Prices: []memoryCatalogPrice{
+				{Amount: 500, LicenseKind: PERMANENT, LimitKind: "PR", LimitValue: 60},
+			}}},
+		}},
+		// ES cannot enforce a limit of days.
+		{"unenforceable limit", memoryCatalog{
+			Titles: []memoryCatalogTitle{title},
+			Items: []memoryCatalogItem{{ItemId: 1, TitleId: testAppTitleId, Prices: []memoryCatalogPrice{
+				{Amount: 100, LicenseKind: RENTAL, LimitKind: "DR", LimitValue: 30},
+			}}},
+		}},
+	}
+
+	for _, test := range tests {
+		s := newGoldenStore()
+		if err := s.loadCatalog(test.catalog); err == nil {
+			t.Errorf("%s: expected catalog to be rejected", test.name)
+		}
+
+		// The catalog previously loaded must remain.
+		if item, _ := s.CatalogItem(1); item == nil {
+			t.Errorf("%s: previous catalog was replaced", test.name)
+		}
+	}
+}
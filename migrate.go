@@ -0,0 +1,167 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	CreateSchemaVersionStatement = `CREATE TABLE IF NOT EXISTS public.schema_version (
+		version integer NOT NULL PRIMARY KEY,
+		name character varying(255) NOT NULL,
+		date_applied timestamp without time zone DEFAULT now() NOT NULL
+	)`
+
+	// LockSchemaVersionStatement ensures only a single WiiSOAP instance migrates at once.
+	LockSchemaVersionStatement = `LOCK TABLE public.schema_version IN EXCLUSIVE MODE`
+
+	QuerySchemaVersionStatement = `SELECT COALESCE(MAX(version), 0) FROM public.schema_version`
+
+	RecordSchemaVersionStatement = `INSERT INTO public.schema_version (version, name) VALUES ($1, $2)`
+)
+
+// migrationFiles contains our schema, as a series of SQL files named in the format 0001_name.sql.
+// Once released, a migration must never be modified; changes belong in a new migration.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration describes a single, versioned change to our schema.
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// loadMigrations returns all embedded migrations, ordered by version.
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	seen := map[int]string{}
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		rawVersion, label, found := strings.Cut(name, "_")
+		if !found {
+			return nil, fmt.Errorf("migration %s is not named in the format 0001_name.sql", entry.Name())
+		}
+
+		version, err := strconv.Atoi(rawVersion)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s has an invalid version", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", other, entry.Name(), version)
+		}
+		seen[version] = entry.Name()
+
+		contents, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{
+			Version: version,
+			Name:    label,
+			SQL:     string(contents),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// querySchemaVersion returns the version of the latest migration applied to the database.
+func querySchemaVersion() (int, error) {
+	var version int
+	err := pool.QueryRow(ctx, QuerySchemaVersionStatement).Scan(&version)
+	return version, err
+}
+
+// applyMigrations applies all migrations newer than the database's current schema version,
+// returning the amount applied. Each migration is applied within its own transaction.
+func applyMigrations() (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = pool.Exec(ctx, CreateSchemaVersionStatement)
+	if err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, current := range migrations {
+		ok, err := applyMigration(current)
+		if err != nil {
+			return applied, fmt.Errorf("applying migration %04d_%s: %w", current.Version, current.Name, err)
+		}
+
+		if ok {
+			log.Printf("Applied migration %04d_%s\n", current.Version, current.Name)
+			applied++
+		}
+	}
+
+	return applied, nil
+}
+
+// applyMigration applies the given migration if the database has not yet done so.
+func applyMigration(current migration) (bool, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, LockSchemaVersionStatement)
+	if err != nil {
+		return false, err
+	}
+
+	// Another instance may have applied this migration while we waited.
+	var version int
+	err = tx.QueryRow(ctx, QuerySchemaVersionStatement).Scan(&version)
+	if err != nil {
+		return false, err
+	}
+	if version >= current.Version {
+		return false, nil
+	}
+
+	// Without any arguments, this is sent as a simple query, permitting multiple statements.
+	_, err = tx.Exec(ctx, current.SQL)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(ctx, RecordSchemaVersionStatement, current.Version, current.Name)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+// runMigrate handles the migrate subcommand, applying migrations and reporting the resulting schema version.
+func runMigrate() {
+	applied, err := applyMigrations()
+	checkError(err)
+
+	version, err := querySchemaVersion()
+	checkError(err)
+
+	fmt.Printf("[i] Applied %d migration(s). The database is at schema version %d.\n", applied, version)
+}
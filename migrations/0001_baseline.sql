@@ -0,0 +1,75 @@
+--
+-- The original WiiMart schema.
+-- Existing databases will already contain these, and so every statement is idempotent.
+--
+
+CREATE TABLE IF NOT EXISTS public.gifted_titles (
+    title_id character(16) NOT NULL,
+    trans_id character(10) NOT NULL,
+    friend_code character(16)
+);
+
+CREATE TABLE IF NOT EXISTS public.owned_titles (
+    account_id integer NOT NULL,
+    title_id character varying(16) NOT NULL,
+    version integer,
+    item_id integer,
+    date_purchased timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.service_titles (
+    item_id integer NOT NULL,
+    price_code integer NOT NULL,
+    price integer NOT NULL,
+    title_id character varying(16) NOT NULL,
+    reference_id character varying(32)
+);
+
+CREATE TABLE IF NOT EXISTS public.userbase (
+    device_id bigint NOT NULL,
+    device_token character varying(21) NOT NULL,
+    device_token_hashed character varying(32) NOT NULL,
+    account_id integer NOT NULL,
+    region character varying(3),
+    serial_number character varying(12),
+    points character varying(6),
+    altregion text DEFAULT 'N/A'::text,
+    og_title character varying(255) DEFAULT 0
+);
+
+-- PostgreSQL does not permit IF NOT EXISTS for constraints.
+DO $$
+BEGIN
+    ALTER TABLE ONLY public.service_titles
+        ADD CONSTRAINT item_id PRIMARY KEY (item_id);
+EXCEPTION WHEN invalid_table_definition OR duplicate_table OR duplicate_object THEN NULL;
+END $$;
+
+DO $$
+BEGIN
+    ALTER TABLE ONLY public.service_titles
+        ADD CONSTRAINT service_titles_reference_id_key UNIQUE (reference_id);
+EXCEPTION WHEN invalid_table_definition OR duplicate_table OR duplicate_object THEN NULL;
+END $$;
+
+DO $$
+BEGIN
+    ALTER TABLE ONLY public.userbase
+        ADD CONSTRAINT userbase_pk PRIMARY KEY (account_id);
+EXCEPTION WHEN invalid_table_definition OR duplicate_table OR duplicate_object THEN NULL;
+END $$;
+
+CREATE INDEX IF NOT EXISTS owned_titles_account_id_uindex ON public.owned_titles USING btree (account_id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS userbase_account_id_uindex ON public.userbase USING btree (account_id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS userbase_device_id_uindex ON public.userbase USING btree (device_id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS userbase_device_token_uindex ON public.userbase USING btree (device_token);
+
+DO $$
+BEGIN
+    ALTER TABLE ONLY public.owned_titles
+        ADD CONSTRAINT order_account_ids FOREIGN KEY (account_id) REFERENCES public.userbase(account_id);
+EXCEPTION WHEN invalid_table_definition OR duplicate_table OR duplicate_object THEN NULL;
+END $$;
@@ -0,0 +1,13 @@
+--
+-- Tickets issued to each account, permitting consoles to restore them as-is.
+--
+
+CREATE TABLE IF NOT EXISTS public.tickets (
+    account_id integer NOT NULL,
+    title_id character varying(16) NOT NULL,
+    ticket_id bigint NOT NULL,
+    ticket bytea NOT NULL,
+    date_issued timestamp without time zone DEFAULT now() NOT NULL,
+    CONSTRAINT tickets_pk PRIMARY KEY (account_id, title_id),
+    CONSTRAINT ticket_account_ids FOREIGN KEY (account_id) REFERENCES public.userbase(account_id)
+);
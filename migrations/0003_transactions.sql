@@ -0,0 +1,42 @@
+--
+-- Transactions for every purchase, gift and points change.
+--
+
+CREATE SEQUENCE IF NOT EXISTS public.transaction_id_seq
+    START WITH 10000000
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1;
+
+-- Transactions intentionally do not reference userbase, as they must outlive removed accounts.
+CREATE TABLE IF NOT EXISTS public.transactions (
+    transaction_id bigint DEFAULT nextval('public.transaction_id_seq'::regclass) NOT NULL,
+    account_id integer NOT NULL,
+    type character varying(16) NOT NULL,
+    title_id character varying(16),
+    item_id integer,
+    total_paid integer DEFAULT 0 NOT NULL,
+    currency character varying(16) DEFAULT 'POINTS'::character varying NOT NULL,
+    reference_id character varying(32),
+    date_created timestamp without time zone DEFAULT now() NOT NULL,
+    CONSTRAINT transactions_pk PRIMARY KEY (transaction_id)
+);
+
+CREATE INDEX IF NOT EXISTS transactions_account_id_index ON public.transactions USING btree (account_id, date_created);
+
+-- Record existing purchases, which were previously not tracked.
+INSERT INTO public.transactions (account_id, type, title_id, item_id, reference_id, date_created)
+    SELECT owned_titles.account_id, 'PURCHGAME', owned_titles.title_id, owned_titles.item_id,
+        service_titles.reference_id, owned_titles.date_purchased
+    FROM public.owned_titles
+    LEFT JOIN public.service_titles ON service_titles.item_id = owned_titles.item_id;
+
+-- Items could previously be purchased repeatedly. Retain only the most recent purchase of each.
+DELETE FROM public.owned_titles a
+    USING public.owned_titles b
+    WHERE a.account_id = b.account_id
+    AND a.item_id = b.item_id
+    AND (a.date_purchased, a.ctid) < (b.date_purchased, b.ctid);
+
+CREATE UNIQUE INDEX IF NOT EXISTS owned_titles_account_item_uindex ON public.owned_titles USING btree (account_id, item_id);
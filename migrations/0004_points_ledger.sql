@@ -0,0 +1,25 @@
+--
+-- An append-only ledger of points changes, replacing userbase.points.
+--
+
+-- Ledger entries intentionally do not reference userbase, as they must outlive removed accounts.
+CREATE TABLE IF NOT EXISTS public.points_ledger (
+    entry_id bigint NOT NULL GENERATED ALWAYS AS IDENTITY,
+    account_id integer NOT NULL,
+    amount integer NOT NULL,
+    balance integer NOT NULL,
+    transaction_id bigint,
+    reason character varying(16) NOT NULL,
+    date_created timestamp without time zone DEFAULT now() NOT NULL,
+    CONSTRAINT points_ledger_balance_check CHECK (balance >= 0),
+    CONSTRAINT points_ledger_pk PRIMARY KEY (entry_id),
+    CONSTRAINT ledger_transaction_ids FOREIGN KEY (transaction_id) REFERENCES public.transactions(transaction_id)
+);
+
+CREATE INDEX IF NOT EXISTS points_ledger_account_id_index ON public.points_ledger USING btree (account_id, entry_id);
+
+-- Carry over balances from userbase.points.
+INSERT INTO public.points_ledger (account_id, amount, balance, reason)
+    SELECT account_id, points::integer, points::integer, 'MIGRATED'
+    FROM public.userbase
+    WHERE points ~ '^[0-9]+$' AND points::integer > 0;
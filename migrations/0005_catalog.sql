@@ -0,0 +1,87 @@
+--
+-- Catalog metadata for titles, their prices, ratings, categories and contents.
+--
+
+CREATE TABLE IF NOT EXISTS public.catalog_titles (
+    title_id character varying(16) NOT NULL,
+    name character varying(255) NOT NULL,
+    platform character varying(16) DEFAULT 'WII'::character varying NOT NULL,
+    publisher character varying(255),
+    CONSTRAINT catalog_titles_pk PRIMARY KEY (title_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.catalog_title_versions (
+    title_id character varying(16) NOT NULL,
+    version integer NOT NULL,
+    title_size bigint DEFAULT 0 NOT NULL,
+    date_released timestamp without time zone DEFAULT now() NOT NULL,
+    CONSTRAINT catalog_title_versions_pk PRIMARY KEY (title_id, version)
+);
+
+CREATE TABLE IF NOT EXISTS public.catalog_prices (
+    price_id integer NOT NULL GENERATED ALWAYS AS IDENTITY,
+    item_id integer NOT NULL,
+    price_code integer NOT NULL,
+    amount integer NOT NULL,
+    currency character varying(16) DEFAULT 'POINTS'::character varying NOT NULL,
+    license_kind character varying(16) DEFAULT 'PERMANENT'::character varying NOT NULL,
+    CONSTRAINT catalog_prices_pk PRIMARY KEY (price_id),
+    CONSTRAINT price_item_ids FOREIGN KEY (item_id) REFERENCES public.service_titles(item_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS catalog_prices_item_id_index ON public.catalog_prices USING btree (item_id);
+
+CREATE TABLE IF NOT EXISTS public.catalog_ratings (
+    title_id character varying(16) NOT NULL,
+    name character varying(16) NOT NULL,
+    rating integer NOT NULL,
+    age integer NOT NULL,
+    CONSTRAINT catalog_ratings_pk PRIMARY KEY (title_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS public.catalog_rating_descriptors (
+    title_id character varying(16) NOT NULL,
+    name character varying(16) NOT NULL,
+    descriptor character varying(64) NOT NULL,
+    CONSTRAINT catalog_rating_descriptors_pk PRIMARY KEY (title_id, name, descriptor)
+);
+
+CREATE TABLE IF NOT EXISTS public.catalog_categories (
+    category_code character varying(16) NOT NULL,
+    name character varying(255) NOT NULL,
+    CONSTRAINT catalog_categories_pk PRIMARY KEY (category_code)
+);
+
+CREATE TABLE IF NOT EXISTS public.catalog_title_categories (
+    title_id character varying(16) NOT NULL,
+    category_code character varying(16) NOT NULL,
+    CONSTRAINT catalog_title_categories_pk PRIMARY KEY (title_id, category_code),
+    CONSTRAINT title_category_codes FOREIGN KEY (category_code) REFERENCES public.catalog_categories(category_code) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS public.catalog_contents (
+    title_id character varying(16) NOT NULL,
+    content_index integer NOT NULL,
+    content_id bigint NOT NULL,
+    content_size bigint DEFAULT 0 NOT NULL,
+    CONSTRAINT catalog_contents_pk PRIMARY KEY (title_id, content_index)
+);
+
+CREATE TABLE IF NOT EXISTS public.catalog_content_sets (
+    content_set_id integer NOT NULL GENERATED ALWAYS AS IDENTITY,
+    title_id character varying(16) NOT NULL,
+    name character varying(255) NOT NULL,
+    CONSTRAINT catalog_content_sets_pk PRIMARY KEY (content_set_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.catalog_content_set_contents (
+    content_set_id integer NOT NULL,
+    content_index integer NOT NULL,
+    CONSTRAINT catalog_content_set_contents_pk PRIMARY KEY (content_set_id, content_index),
+    CONSTRAINT content_set_ids FOREIGN KEY (content_set_id) REFERENCES public.catalog_content_sets(content_set_id) ON DELETE CASCADE
+);
+
+-- Carry over prices from service_titles, which previously permitted a single price per item.
+INSERT INTO public.catalog_prices (item_id, price_code, amount)
+    SELECT item_id, price_code, price
+    FROM public.service_titles;
@@ -0,0 +1,21 @@
+--
+-- Copies of accounts and their owned titles retained after unregistering.
+--
+
+CREATE TABLE IF NOT EXISTS public.userbase_archive (
+    device_id bigint NOT NULL,
+    account_id integer NOT NULL,
+    region character varying(3),
+    serial_number character varying(12),
+    og_title character varying(255),
+    date_archived timestamp without time zone DEFAULT now() NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.owned_titles_archive (
+    account_id integer NOT NULL,
+    title_id character varying(16) NOT NULL,
+    version integer,
+    item_id integer,
+    date_purchased timestamp without time zone NOT NULL,
+    date_archived timestamp without time zone DEFAULT now() NOT NULL
+);
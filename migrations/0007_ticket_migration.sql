@@ -0,0 +1,7 @@
+--
+-- Track how often tickets have been moved to another console.
+--
+
+ALTER TABLE public.tickets
+    ADD COLUMN IF NOT EXISTS migrate_count integer DEFAULT 0 NOT NULL,
+    ADD COLUMN IF NOT EXISTS migrate_limit integer DEFAULT 3 NOT NULL;
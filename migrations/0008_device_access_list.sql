@@ -0,0 +1,32 @@
+--
+-- Consoles explicitly permitted or rejected from using the shop.
+--
+
+CREATE TABLE IF NOT EXISTS public.device_access_list (
+    entry_id integer NOT NULL GENERATED ALWAYS AS IDENTITY,
+    serial_number character varying(12),
+    device_id bigint,
+    kind character varying(5) NOT NULL,
+    reason text,
+    expires_at timestamp without time zone,
+    date_created timestamp without time zone DEFAULT now() NOT NULL,
+    CONSTRAINT device_access_list_pk PRIMARY KEY (entry_id),
+    CONSTRAINT device_access_list_kind_check CHECK (kind IN ('allow', 'block')),
+    CONSTRAINT device_access_list_target_check CHECK (serial_number IS NOT NULL OR device_id IS NOT NULL)
+);
+
+-- Notify WiiSOAP of any changes so that its cached copy may be reloaded.
+CREATE OR REPLACE FUNCTION public.notify_device_access_list() RETURNS trigger
+    LANGUAGE plpgsql
+    AS $$
+BEGIN
+    PERFORM pg_notify('device_access_list', '');
+    RETURN NULL;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS device_access_list_notify ON public.device_access_list;
+
+CREATE TRIGGER device_access_list_notify
+    AFTER INSERT OR DELETE OR UPDATE OR TRUNCATE ON public.device_access_list
+    FOR EACH STATEMENT EXECUTE FUNCTION public.notify_device_access_list();
@@ -0,0 +1,11 @@
+--
+-- Remove columns superseded by the points ledger and catalog prices.
+-- Their contents were carried over by earlier migrations.
+--
+
+ALTER TABLE public.userbase
+    DROP COLUMN IF EXISTS points;
+
+ALTER TABLE public.service_titles
+    DROP COLUMN IF EXISTS price_code,
+    DROP COLUMN IF EXISTS price;
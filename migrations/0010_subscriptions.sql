@@ -0,0 +1,39 @@
+--
+-- Subscriptions for service titles, such as Wii no Ma's theatre.
+--
+
+-- Service titles declaring a subscription, and how it is renewed.
+-- "extend" adds the duration to an active subscription, whereas "reset" begins it anew from purchase.
+CREATE TABLE IF NOT EXISTS public.catalog_subscriptions (
+    title_id character varying(16) NOT NULL,
+    duration_days integer NOT NULL,
+    renewal character varying(16) DEFAULT 'extend'::character varying NOT NULL,
+    CONSTRAINT catalog_subscriptions_pk PRIMARY KEY (title_id),
+    CONSTRAINT catalog_subscriptions_duration CHECK (duration_days > 0),
+    CONSTRAINT catalog_subscriptions_renewal CHECK (renewal IN ('extend', 'reset'))
+);
+
+-- Subscriptions purchased by accounts, one per item.
+CREATE TABLE IF NOT EXISTS public.subscriptions (
+    account_id integer NOT NULL,
+    item_id integer NOT NULL,
+    title_id character varying(16) NOT NULL,
+    reference_id character varying(32),
+    date_expires timestamp without time zone NOT NULL,
+    CONSTRAINT subscriptions_pk PRIMARY KEY (account_id, item_id)
+);
+
+CREATE INDEX IF NOT EXISTS subscriptions_account_title_index ON public.subscriptions USING btree (account_id, title_id);
+
+-- Wii no Ma's theatre was previously the only subscription, lasting 30 days from each purchase.
+INSERT INTO public.catalog_subscriptions (title_id, duration_days, renewal)
+    VALUES ('000101006843494A', 30, 'reset')
+    ON CONFLICT (title_id) DO NOTHING;
+
+INSERT INTO public.subscriptions (account_id, item_id, title_id, reference_id, date_expires)
+    SELECT owned_titles.account_id, owned_titles.item_id, service_titles.title_id, service_titles.reference_id,
+        owned_titles.date_purchased + interval '30 days'
+    FROM public.owned_titles, public.service_titles
+    WHERE owned_titles.item_id = service_titles.item_id
+    AND service_titles.title_id = '000101006843494A'
+    ON CONFLICT (account_id, item_id) DO NOTHING;
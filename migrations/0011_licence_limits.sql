@@ -0,0 +1,26 @@
+--
+-- Limits on licences, such as rentals and trials.
+--
+
+-- Each price grants a licence with at most one limit. Values are interpreted by limit kind:
+-- TR limits play time in minutes, DR expires the licence the given amount of minutes after purchase,
+-- and LR limits the amount of launches. PR licences are permanent, and have no value.
+ALTER TABLE public.catalog_prices
+    ADD COLUMN IF NOT EXISTS limit_kind character varying(2) DEFAULT 'PR'::character varying NOT NULL,
+    ADD COLUMN IF NOT EXISTS limit_value integer DEFAULT 0 NOT NULL;
+
+DO $$
+BEGIN
+    ALTER TABLE ONLY public.catalog_prices
+        ADD CONSTRAINT catalog_prices_limit CHECK (
+            (limit_kind = 'PR' AND limit_value = 0)
+            OR (limit_kind IN ('TR', 'DR', 'LR') AND limit_value > 0)
+        );
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+-- The licence a purchase was made under. Transactions recorded beforehand are permanent.
+ALTER TABLE public.transactions
+    ADD COLUMN IF NOT EXISTS license_kind character varying(16),
+    ADD COLUMN IF NOT EXISTS limit_kind character varying(2),
+    ADD COLUMN IF NOT EXISTS limit_value integer;
@@ -0,0 +1,22 @@
+--
+-- Purchases of individual contents within a title, such as downloadable content.
+--
+
+-- Items granting a content set, rather than their title as a whole.
+ALTER TABLE public.service_titles
+    ADD COLUMN IF NOT EXISTS content_set_id integer;
+
+DO $$
+BEGIN
+    ALTER TABLE ONLY public.service_titles
+        ADD CONSTRAINT service_titles_content_set_ids FOREIGN KEY (content_set_id) REFERENCES public.catalog_content_sets(content_set_id);
+EXCEPTION WHEN duplicate_object THEN NULL;
+END $$;
+
+-- Contents purchased by accounts, accumulated across purchases of content sets within a title.
+CREATE TABLE IF NOT EXISTS public.owned_contents (
+    account_id integer NOT NULL,
+    title_id character varying(16) NOT NULL,
+    content_index integer NOT NULL,
+    CONSTRAINT owned_contents_pk PRIMARY KEY (account_id, title_id, content_index)
+);
@@ -0,0 +1,13 @@
+--
+-- Gifts addressed to a single recipient, granting the item they were purchased as.
+--
+
+-- The friend code each console registered with, identifying it as the recipient of gifts.
+-- Consoles registered prior to this migration must register again to receive gifts.
+ALTER TABLE public.userbase
+    ADD COLUMN IF NOT EXISTS device_code character varying(16);
+
+-- Gifts pending prior to this migration have no recipient, and so cannot be accepted.
+ALTER TABLE public.gifted_titles
+    ADD COLUMN IF NOT EXISTS item_id integer,
+    ADD COLUMN IF NOT EXISTS recipient_code character varying(16);
@@ -0,0 +1,15 @@
+--
+-- Restrict licence limits to those tickets can enforce.
+--
+
+-- Consoles only enforce play time (TR) and launch (LR) limits, and so DR prices can never be honoured.
+DELETE FROM public.catalog_prices WHERE limit_kind = 'DR';
+
+ALTER TABLE ONLY public.catalog_prices
+    DROP CONSTRAINT IF EXISTS catalog_prices_limit;
+
+ALTER TABLE ONLY public.catalog_prices
+    ADD CONSTRAINT catalog_prices_limit CHECK (
+        (limit_kind = 'PR' AND limit_value = 0)
+        OR (limit_kind IN ('TR', 'LR') AND limit_value > 0)
+    );
@@ -0,0 +1,7 @@
+--
+-- Track how often accounts have been moved to another console, as with their tickets,
+-- such that accounts holding no tickets remain subject to the migration limit.
+--
+
+ALTER TABLE public.userbase
+    ADD COLUMN IF NOT EXISTS migrate_count integer DEFAULT 0 NOT NULL;
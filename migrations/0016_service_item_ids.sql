@@ -0,0 +1,18 @@
+--
+-- Allocate item IDs from a sequence, such that concurrent imports and catalog syncs
+-- never allocate the same ID, and IDs of removed items are never reused.
+--
+
+CREATE SEQUENCE IF NOT EXISTS public.service_item_id_seq
+    AS integer
+    INCREMENT BY 1
+    NO MINVALUE
+    NO MAXVALUE
+    CACHE 1
+    OWNED BY public.service_titles.item_id;
+
+-- Continue from the highest item ID previously allocated.
+SELECT setval('public.service_item_id_seq', COALESCE((SELECT MAX(item_id) FROM public.service_titles), 0) + 1, false);
+
+ALTER TABLE public.service_titles
+    ALTER COLUMN item_id SET DEFAULT nextval('public.service_item_id_seq'::regclass);
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/wii-tools/wadlib"
+)
+
+// systemTitles contains all system titles offered via NUS, as configured.
+var systemTitles []SystemTitle
+
+// loadSystemTitles validates the given system titles, offering them via NUS.
+func loadSystemTitles(titles []SystemTitle) error {
+	for i, title := range titles {
+		title.TitleId = strings.ToUpper(strings.TrimSpace(title.TitleId))
+		if len(title.TitleId) != 16 {
+			return fmt.Errorf("system title %q must be 16 characters in length", title.TitleId)
+		}
+		if _, err := strconv.ParseUint(title.TitleId, 16, 64); err != nil {
+			return fmt.Errorf("system title %q is not a valid title ID", title.TitleId)
+		}
+		if title.Version < 0 || title.Version > 0xFFFF {
+			return fmt.Errorf("system title %s has an invalid version %d", title.TitleId, title.Version)
+		}
+
+		titles[i] = title
+	}
+
+	systemTitles = titles
+	return nil
+}
+
+// regionSystemTitles returns the system titles offered to consoles of the given region.
+func regionSystemTitles(region string) []SystemTitle {
+	var titles []SystemTitle
+	for _, title := range systemTitles {
+		if title.Region == "" || title.Region == region {
+			titles = append(titles, title)
+		}
+	}
+	return titles
+}
+
+// systemTitleHash summarises the given titles and their versions.
+// Consoles only request an update should this change from the hash of their last update.
+func systemTitleHash(titles []SystemTitle) string {
+	hash := md5.New()
+	for _, title := range titles {
+		fmt.Fprintf(hash, "%s:%d\n", title.TitleId, title.Version)
+	}
+	return fmt.Sprintf("%X", hash.Sum(nil))
+}
+
+func getSystemUpdate(e *Envelope) error {
+	contentUrl := contentPrefixUrl()
+	e.AddKVNode("ContentPrefixURL", contentUrl)
+	e.AddKVNode("UncachedContentPrefixURL", contentUrl)
+
+	for _, title := range regionSystemTitles(e.Region()) {
+		e.AddCustomType(TitleVersion{
+			TitleId: title.TitleId,
+			Version: title.Version,
+			FsSize:  title.FsSize,
+		})
+	}
+
+	// We have no use for the console's audit data.
+	e.AddKVNode("UploadAuditData", "0")
+
+	return nil
+}
+
+func getSystemTitleHash(e *Envelope) error {
+	e.AddKVNode("TitleHash", systemTitleHash(regionSystemTitles(e.Region())))
+
+	return nil
+}
+
+// GetSystemCommonETicketRequest describes the GetSystemCommonETicket action.
+type GetSystemCommonETicketRequest struct {
+	TitleId []string `xml:"TitleId" soap:"required"`
+}
+
+func getSystemCommonETicket(e *Envelope, request *GetSystemCommonETicketRequest) error {
+	offered := map[string]SystemTitle{}
+	for _, title := range regionSystemTitles(e.Region()) {
+		offered[title.TitleId] = title
+	}
+
+	for _, titleId := range request.TitleId {
+		title, ok := offered[strings.ToUpper(strings.TrimSpace(titleId))]
+		if !ok {
+			return actionError(ErrorCodeTitleNotFound, "title is not a system title", errors.New("no system title "+titleId))
+		}
+
+		// Common tickets are not personalised to any account or console.
+		ticket, err := newTicket(0, 0, title.TitleId, title.Version)
+		if err != nil {
+			return actionError(ErrorCodeServerError, "failed to create ticket", err)
+		}
+		contents, err := encodeTicket(ticket)
+		if err != nil {
+			return actionError(ErrorCodeServerError, "failed to create ticket", err)
+		}
+
+		e.AddKVNode("CommonETicket", b64(contents))
+	}
+
+	// Two cert types must be present.
+	e.AddKVNode("Certs", b64(wadlib.CertChainTemplate))
+	e.AddKVNode("Certs", b64(wadlib.CertChainTemplate))
+
+	return nil
+}
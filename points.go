@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	// LockAccountStatement serializes points changes for a single account
+	// until the surrounding transaction completes.
+	LockAccountStatement = `SELECT 1 FROM userbase WHERE account_id = $1 FOR UPDATE`
+
+	QueryBalanceStatement = `SELECT balance FROM points_ledger
+		WHERE account_id = $1
+		ORDER BY entry_id DESC
+		LIMIT 1`
+
+	AppendLedgerStatement = `INSERT INTO points_ledger (account_id, amount, balance, transaction_id, reason)
+		VALUES ($1, $2, $3, $4, $5)`
+)
+
+var (
+	ErrInsufficientPoints = errors.New("insufficient points balance")
+	ErrUnknownAccount     = errors.New("account does not exist")
+)
+
+// queryBalance returns the current points balance for the given account.
+// Accounts without any ledger entries have a balance of zero.
+func queryBalance(q querier, accountId int64) (int, error) {
+	var balance int
+	err := q.QueryRow(ctx, QueryBalanceStatement, accountId).Scan(&balance)
+	if err == pgx.ErrNoRows {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return balance, nil
+}
+
+// adjustPoints records a credit (positive amount) or debit (negative amount)
+// against the given account within the given transaction, returning the resulting balance.
+// If the account has insufficient funds, ErrInsufficientPoints is returned and nothing is recorded.
+func adjustPoints(tx pgx.Tx, accountId int64, amount int, transactionId int64, reason TransactionType) (int, error) {
+	// Lock the account so that concurrent changes cannot observe the same balance.
+	var throwaway int
+	err := tx.QueryRow(ctx, LockAccountStatement, accountId).Scan(&throwaway)
+	if err == pgx.ErrNoRows {
+		return 0, ErrUnknownAccount
+	} else if err != nil {
+		return 0, err
+	}
+
+	balance, err := queryBalance(tx, accountId)
+	if err != nil {
+		return 0, err
+	}
+
+	balance += amount
+	if balance < 0 {
+		return 0, ErrInsufficientPoints
+	}
+
+	_, err = tx.Exec(ctx, AppendLedgerStatement, accountId, amount, balance, transactionId, reason)
+	if err != nil {
+		return 0, err
+	}
+
+	return balance, nil
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	PrepareUserStatement = `INSERT INTO userbase
+		(device_id, device_token, device_token_hashed, account_id, region, serial_number, og_title, device_code)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	SyncUserStatement = `SELECT
+		account_id, device_id, device_token, device_token_hashed, region, serial_number, COALESCE(og_title, ''),
+		COALESCE(device_code, '')
+	FROM userbase WHERE
+		region = $1 AND
+		device_id = $2`
+	ArchiveUserStatement = `INSERT INTO userbase_archive
+		(device_id, account_id, region, serial_number, og_title)
+	SELECT device_id, account_id, region, serial_number, og_title
+	FROM userbase WHERE account_id = $1`
+	ArchiveOwnedTitlesStatement = `INSERT INTO owned_titles_archive
+		(account_id, title_id, version, item_id, date_purchased)
+	SELECT account_id, title_id, version, item_id, date_purchased
+	FROM owned_titles WHERE account_id = $1`
+	LockDeviceUserStatement = `SELECT 1 FROM userbase
+	WHERE account_id = $1 AND device_id = $2
+	FOR UPDATE`
+	CheckUserInUseStatement = `SELECT
+		EXISTS (SELECT 1 FROM owned_titles WHERE account_id = $1) OR
+		EXISTS (SELECT 1 FROM transactions WHERE account_id = $1)`
+	MoveUserStatement = `UPDATE userbase
+	SET device_id = $2, serial_number = $3, device_code = $4, migrate_count = migrate_count + 1
+	WHERE account_id = $1 AND migrate_count < $5`
+	MoveGiftsStatement = `UPDATE gifted_titles
+	SET recipient_code = $2
+	WHERE recipient_code = (SELECT device_code FROM userbase WHERE account_id = $1)`
+	RemoveTicketsStatement     = `DELETE FROM tickets WHERE account_id = $1`
+	RemoveOwnedTitlesStatement = `DELETE FROM owned_titles WHERE account_id = $1`
+	RemoveUserStatement        = `DELETE FROM userbase WHERE account_id = $1`
+	CheckUserStatement         = `SELECT
+		1
+	FROM userbase WHERE
+		device_id = $1 AND
+		serial_number = $2 AND
+		region = $3`
+
+	RouteVerifyHashedStatement   = `SELECT 1 FROM userbase WHERE device_token_hashed=$1 AND account_id=$2 AND device_id=$3`
+	RouteVerifyUnhashedStatement = `SELECT 1 FROM userbase WHERE device_token=$1 AND account_id=$2 AND device_id=$3`
+
+	QueryOwnedTitles = `SELECT owned_titles.title_id, MAX(owned_titles.version)
+		FROM owned_titles
+		WHERE owned_titles.account_id = $1
+		GROUP BY owned_titles.title_id
+		ORDER BY owned_titles.title_id`
+
+	AssociateTicketStatement = `INSERT INTO owned_titles (account_id, title_id, version, item_id, date_purchased)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (account_id, item_id) DO UPDATE
+		SET version = EXCLUDED.version, date_purchased = EXCLUDED.date_purchased`
+
+	AssociateGiftedTitleStatement = `INSERT INTO public.gifted_titles (title_id, trans_id, friend_code, item_id, recipient_code)
+		VALUES ($1, $2, $3, $4, $5)`
+
+	QueryGiftedTitleStatement = `SELECT gifted_titles.friend_code, service_titles.item_id
+		FROM public.gifted_titles, public.service_titles, public.userbase
+		WHERE gifted_titles.item_id = service_titles.item_id
+		AND lower(gifted_titles.title_id) = lower(service_titles.title_id)
+		AND gifted_titles.recipient_code = userbase.device_code
+		AND gifted_titles.title_id = $1
+		AND gifted_titles.trans_id = $2
+		AND userbase.account_id = $3`
+
+	RemoveGiftedTitleStatement = `DELETE FROM public.gifted_titles
+		WHERE title_id = $1 AND trans_id = $2`
+)
+
+// postgresStore persists state within PostgreSQL.
+type postgresStore struct {
+	pool *pgxpool.Pool
+}
+
+// newPostgresStore returns a Store backed by the given connection pool.
+func newPostgresStore(pool *pgxpool.Pool) Store {
+	return &postgresStore{pool: pool}
+}
+
+// inTx runs the given function within a transaction, committing only if it succeeds.
+func (s *postgresStore) inTx(f func(tx pgx.Tx) error) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = f(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// lockDeviceUser ensures the given account is registered to the given device,
+// preventing changes to it until the surrounding transaction completes.
+func lockDeviceUser(tx pgx.Tx, accountId int64, deviceId int) error {
+	var throwaway int
+	err := tx.QueryRow(ctx, LockDeviceUserStatement, accountId, deviceId).Scan(&throwaway)
+	if err == pgx.ErrNoRows {
+		return ErrUnknownAccount
+	}
+	return err
+}
+
+// isUniqueViolation determines whether the given error is due to a unique constraint.
+func isUniqueViolation(err error) bool {
+	if driverErr, ok := err.(*pgconn.PgError); ok {
+		return driverErr.Code == "23505"
+	}
+	return false
+}
+
+func (s *postgresStore) CreateUser(user User) error {
+	_, err := s.pool.Exec(ctx, PrepareUserStatement, user.DeviceId, user.DeviceToken, user.DeviceTokenHashed,
+		user.AccountId, user.Region, user.SerialNumber, user.OriginalTitle, user.DeviceCode)
+	if isUniqueViolation(err) {
+		return ErrUserExists
+	}
+	return err
+}
+
+func (s *postgresStore) QueryUser(region string, deviceId int) (*User, error) {
+	var user User
+	err := s.pool.QueryRow(ctx, SyncUserStatement, region, deviceId).Scan(&user.AccountId, &user.DeviceId,
+		&user.DeviceToken, &user.DeviceTokenHashed, &user.Region, &user.SerialNumber, &user.OriginalTitle, &user.DeviceCode)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (s *postgresStore) IsRegistered(deviceId int, serialNumber string, region string) (bool, error) {
+	var throwaway int
+	err := s.pool.QueryRow(ctx, CheckUserStatement, deviceId, serialNumber, region).Scan(&throwaway)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *postgresStore) VerifyToken(accountId int64, deviceId int, token string, tokenType TokenType) (bool, error) {
+	statement := RouteVerifyUnhashedStatement
+	if tokenType == TokenTypeHashed {
+		statement = RouteVerifyHashedStatement
+	}
+
+	var throwaway int
+	err := s.pool.QueryRow(ctx, statement, token, accountId, deviceId).Scan(&throwaway)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *postgresStore) RemoveUser(accountId int64, deviceId int, archive bool) error {
+	return s.inTx(func(tx pgx.Tx) error {
+		err := lockDeviceUser(tx, accountId, deviceId)
+		if err != nil {
+			return err
+		}
+
+		var statements []string
+		if archive {
+			statements = append(statements, ArchiveUserStatement, ArchiveOwnedTitlesStatement)
+		}
+		statements = append(statements, RemoveTicketsStatement, RemoveOwnedContentsStatement, RemoveOwnedTitlesStatement, RemoveUserStatement)
+
+		for _, statement := range statements {
+			_, err = tx.Exec(ctx, statement, accountId)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *postgresStore) MoveUser(accountId int64, deviceId int, target User) error {
+	return s.inTx(func(tx pgx.Tx) error {
+		err := lockDeviceUser(tx, accountId, deviceId)
+		if err != nil {
+			return err
+		}
+
+		// The target account was only registered to permit this move, and must not have been used.
+		err = lockDeviceUser(tx, target.AccountId, target.DeviceId)
+		if err != nil {
+			return err
+		}
+		var inUse bool
+		err = tx.QueryRow(ctx, CheckUserInUseStatement, target.AccountId).Scan(&inUse)
+		if err != nil {
+			return err
+		} else if inUse {
+			return ErrDeviceExists
+		}
+
+		tickets, err := queryStoredTickets(tx, accountId)
+		if err != nil {
+			return err
+		}
+		for _, ticket := range tickets {
+			if ticket.MigrateCount >= ticket.MigrateLimit {
+				return ErrMigrateLimitReached
+			}
+		}
+
+		_, err = tx.Exec(ctx, RemoveTicketsStatement, target.AccountId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, RemoveUserStatement, target.AccountId)
+		if err != nil {
+			return err
+		}
+
+		// Gifts pending acceptance by this console follow the account.
+		_, err = tx.Exec(ctx, MoveGiftsStatement, accountId, target.DeviceCode)
+		if err != nil {
+			return err
+		}
+
+		// The account itself is subject to the limit, as subscriptions and gifts move with it.
+		result, err := tx.Exec(ctx, MoveUserStatement, accountId, target.DeviceId, target.SerialNumber, target.DeviceCode, migrateLimit)
+		if isUniqueViolation(err) {
+			return ErrDeviceExists
+		} else if err != nil {
+			return err
+		} else if result.RowsAffected() == 0 {
+			return ErrMigrateLimitReached
+		}
+
+		for _, ticket := range tickets {
+			err = migrateTicket(tx, accountId, ticket, target.DeviceId)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *postgresStore) OwnedTitles(accountId int64) ([]ownedTitle, error) {
+	rows, err := s.pool.Query(ctx, QueryOwnedTitles, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var titles []ownedTitle
+	for rows.Next() {
+		var title ownedTitle
+		var version *int
+		err = rows.Scan(&title.TitleId, &version)
+		if err != nil {
+			return nil, err
+		}
+
+		if version != nil {
+			title.Version = *version
+		}
+		titles = append(titles, title)
+	}
+
+	return titles, rows.Err()
+}
+
+func (s *postgresStore) OwnedContents(accountId int64, titleId string) ([]int, error) {
+	return queryOwnedContents(s.pool, accountId, titleId)
+}
+
+func (s *postgresStore) Subscriptions(accountId int64, titleId string) ([]subscription, error) {
+	return querySubscriptions(s.pool, accountId, titleId)
+}
+
+func (s *postgresStore) Tickets(accountId int64) ([]storedTicket, error) {
+	return queryStoredTickets(s.pool, accountId)
+}
+
+func (s *postgresStore) IssueTicket(accountId int64, grant ticketGrant) ([]byte, error) {
+	return issueTicket(s.pool, accountId, grant)
+}
+
+func (s *postgresStore) Balance(accountId int64) (int, error) {
+	return queryBalance(s.pool, accountId)
+}
+
+func (s *postgresStore) Transactions(accountId int64, titleId string, offset int, size int) ([]transactionRecord, int, error) {
+	return queryTransactions(s.pool, accountId, titleId, offset, size)
+}
+
+func (s *postgresStore) PurchaseTitle(accountId int64, record *transactionRecord, version int, grant ticketGrant, renewed *subscription) (int, []byte, error) {
+	var balance int
+	var ticket []byte
+	err := s.inTx(func(tx pgx.Tx) error {
+		err := recordTransaction(tx, accountId, record)
+		if err != nil {
+			return err
+		}
+
+		balance, err = adjustPoints(tx, accountId, -record.TotalPaid, record.TransactionId, record.Type)
+		if err != nil {
+			return err
+		}
+
+		ticket, err = issueTicket(tx, accountId, grant)
+		if err != nil {
+			return err
+		}
+
+		if renewed != nil {
+			err = storeSubscription(tx, accountId, *renewed)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = storeOwnedContents(tx, accountId, grant.TitleId, grant.Contents)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, AssociateTicketStatement, accountId, record.TitleId, version, record.ItemId, record.Date)
+		return err
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return balance, ticket, nil
+}
+
+func (s *postgresStore) PurchasePoints(accountId int64, record *transactionRecord, points int) (int, error) {
+	var balance int
+	err := s.inTx(func(tx pgx.Tx) error {
+		err := recordTransaction(tx, accountId, record)
+		if err != nil {
+			return err
+		}
+
+		balance, err = adjustPoints(tx, accountId, points, record.TransactionId, record.Type)
+		return err
+	})
+
+	return balance, err
+}
+
+func (s *postgresStore) GiftTitle(accountId int64, record *transactionRecord, senderFriendCode string, recipientFriendCode string) (int, int64, error) {
+	var balance int
+	var recipientTransactionId int64
+	err := s.inTx(func(tx pgx.Tx) error {
+		err := recordTransaction(tx, accountId, record)
+		if err != nil {
+			return err
+		}
+
+		// The recipient's transaction is recorded once they accept the gift.
+		recipientTransactionId, err = reserveTransactionId(tx)
+		if err != nil {
+			return err
+		}
+
+		balance, err = adjustPoints(tx, accountId, -record.TotalPaid, record.TransactionId, record.Type)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, AssociateGiftedTitleStatement, record.TitleId, formatTransactionId(recipientTransactionId),
+			senderFriendCode, record.ItemId, recipientFriendCode)
+		return err
+	})
+
+	return balance, recipientTransactionId, err
+}
+
+func (s *postgresStore) AcceptGift(accountId int64, transactionId int64, version int, grant ticketGrant) (*gift, []byte, error) {
+	accepted := gift{TitleId: grant.TitleId}
+	var ticket []byte
+	err := s.inTx(func(tx pgx.Tx) error {
+		// Ensure this gift exists, was addressed to this account's console, and is of an item we sell.
+		transId := formatTransactionId(transactionId)
+		err := tx.QueryRow(ctx, QueryGiftedTitleStatement, grant.TitleId, transId, accountId).Scan(&accepted.SenderFriendCode, &accepted.ItemId)
+		if err == pgx.ErrNoRows {
+			return ErrGiftNotFound
+		} else if err != nil {
+			return err
+		}
+
+		// The gifted title now belongs to the recipient.
+		now := time.Now().UTC()
+		_, err = tx.Exec(ctx, AssociateTicketStatement, accountId, grant.TitleId, version, accepted.ItemId, now)
+		if err != nil {
+			return err
+		}
+
+		err = recordTransaction(tx, accountId, &transactionRecord{
+			TransactionId: transactionId,
+			Type:          TransactionGiftReceived,
+			TitleId:       grant.TitleId,
+			ItemId:        accepted.ItemId,
+			Date:          now,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, RemoveGiftedTitleStatement, grant.TitleId, transId)
+		if err != nil {
+			return err
+		}
+
+		ticket, err = issueTicket(tx, accountId, grant)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &accepted, ticket, nil
+}
+
+func (s *postgresStore) CatalogItems(filter catalogFilter) ([]catalogItem, int, error) {
+	return queryCatalogItems(s.pool, filter)
+}
+
+func (s *postgresStore) CatalogItem(itemId int) (*catalogItem, error) {
+	return queryCatalogItem(s.pool, itemId)
+}
+
+func (s *postgresStore) CatalogTitles(category string, platform string, offset int, size int) ([]TitleInfo, int, error) {
+	return queryCatalogTitles(s.pool, category, platform, offset, size)
+}
+
+func (s *postgresStore) CatalogTitle(titleId string) (*TitleInfo, error) {
+	return queryCatalogTitle(s.pool, titleId)
+}
+
+func (s *postgresStore) Categories() ([]Category, error) {
+	return queryCategories(s.pool, QueryCategoriesStatement)
+}
+
+func (s *postgresStore) ContentSets(titleId string) ([]ContentSet, error) {
+	return queryContentSets(s.pool, titleId)
+}
+
+func (s *postgresStore) SubscriptionPlan(titleId string) (*subscriptionPlan, error) {
+	return querySubscriptionPlan(s.pool, titleId)
+}
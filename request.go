@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+)
+
+// commonRequest contains fields sent with every request.
+type commonRequest struct {
+	Version   string `xml:"Version" soap:"required"`
+	DeviceId  int    `xml:"DeviceId" soap:"required"`
+	MessageId string `xml:"MessageId" soap:"required"`
+	Region    string `xml:"Region"`
+	Country   string `xml:"Country"`
+	Language  string `xml:"Language" soap:"required"`
+
+	// NUS names Region and Country differently.
+	RegionId    string `xml:"RegionId"`
+	CountryCode string `xml:"CountryCode"`
+
+	// These are only sent within requests requiring authentication.
+	DeviceToken string `xml:"DeviceToken"`
+	AccountId   string `xml:"AccountId"`
+}
+
+// NameValue describes a repeated Name and Value pair, such as those within AttributeFilters.
+type NameValue struct {
+	Name  string `xml:"Name"`
+	Value string `xml:"Value"`
+}
+
+// nameValues returns the given pairs as a map, keyed by name.
+func nameValues(pairs []NameValue) map[string]string {
+	values := map[string]string{}
+	for _, pair := range pairs {
+		values[strings.TrimSpace(pair.Name)] = strings.TrimSpace(pair.Value)
+	}
+	return values
+}
+
+// RequestPrice describes the price a console has agreed to pay.
+type RequestPrice struct {
+	Amount   string `xml:"Amount"`
+	Currency string `xml:"Currency"`
+}
+
+// RequestLimits describes the limits of the licence a console has chosen, such as a rental.
+type RequestLimits struct {
+	Limits    string `xml:"Limits"`
+	LimitKind string `xml:"LimitKind"`
+}
+
+var errInvalidListRange = errors.New("invalid list result offset or size")
+
+// listRange contains the ListResultOffset and ListResultSize keys sent by the console.
+// Either may be absent, in which case all results from the start are returned.
+type listRange struct {
+	ListResultOffset int  `xml:"ListResultOffset"`
+	ListResultSize   *int `xml:"ListResultSize"`
+}
+
+// Range returns the offset and size requested.
+func (l listRange) Range() (int, int, error) {
+	size := math.MaxInt32
+	if l.ListResultSize != nil {
+		size = *l.ListResultSize
+	}
+
+	if l.ListResultOffset < 0 || size < 0 {
+		return 0, 0, errInvalidListRange
+	}
+	return l.ListResultOffset, size, nil
+}
+
+// validator is implemented by requests requiring validation beyond the presence of their fields.
+type validator interface {
+	Validate() error
+}
+
+// decode interprets the children of this request's action element into the given struct.
+// Fields tagged with soap:"required" must be present as a direct child.
+func (e *Envelope) decode(v interface{}) error {
+	err := xml.Unmarshal([]byte(e.doc.OutputXML(true)), v)
+	if err != nil {
+		return actionError(ErrorCodeInvalidRequest, "invalid request", err)
+	}
+
+	present := map[string]bool{}
+	for child := e.doc.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == xmlquery.ElementNode {
+			present[child.Data] = true
+		}
+	}
+
+	err = checkRequired(reflect.TypeOf(v).Elem(), present)
+	if err != nil {
+		return actionError(ErrorCodeInvalidRequest, "invalid request", err)
+	}
+	return nil
+}
+
+// checkRequired ensures all fields tagged with soap:"required" within the given struct were present.
+func checkRequired(t reflect.Type, present map[string]bool) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if err := checkRequired(field.Type, present); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if field.Tag.Get("soap") != "required" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("xml"), ",")
+		if name == "" {
+			name = field.Name
+		}
+		if !present[name] {
+			return errors.New("missing mandatory key named " + name)
+		}
+	}
+
+	return nil
+}
+
+// handle returns an action decoding its request into T, calling the given function once valid.
+func handle[T any](function func(e *Envelope, request *T) error) func(e *Envelope) error {
+	return func(e *Envelope) error {
+		var request T
+		err := e.decode(&request)
+		if err != nil {
+			return err
+		}
+
+		if v, ok := interface{}(&request).(validator); ok {
+			err = v.Validate()
+			if err != nil {
+				return err
+			}
+		}
+
+		return function(e, &request)
+	}
+}
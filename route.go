@@ -1,7 +1,9 @@
 package main
 
 import (
-	"github.com/jackc/pgx/v4"
+	"encoding/xml"
+	"errors"
+	"fmt"
 	"github.com/logrusorgru/aurora/v3"
 	"io/ioutil"
 	"log"
@@ -9,24 +11,43 @@ import (
 	"strings"
 )
 
-// Route defines a header to be checked for actions, and an array of actions to handle.
+const (
+	// FaultClient indicates a request was malformed, and should not be retried as-is.
+	FaultClient = "soapenv:Client"
+	// FaultServer indicates a request could not be handled due to a server-side failure.
+	FaultServer = "soapenv:Server"
+)
+
+// Route defines a header to be checked for actions, and the actions to handle.
 type Route struct {
 	HeaderName string
-	Actions    []Action
+	// Actions contains all registered actions, keyed by their namespace and name.
+	Actions map[actionKey]Action
+	// Services maps the namespace of each service to its type, such as urn:ecs.wsapi.broadon.com to ecs.
+	Services map[string]string
+}
+
+// actionKey identifies an action within the namespace of its service.
+type actionKey struct {
+	Namespace  string
+	ActionName string
 }
 
 // Action contains information about how a specified action should be handled.
 type Action struct {
 	ActionName          string
-	Callback            func(e *Envelope)
+	Callback            func(e *Envelope) error
 	NeedsAuthentication bool
 	ServiceType         string
+	Namespace           string
 }
 
 // NewRoute produces a new route struct with appropriate header defaults.
 func NewRoute() Route {
 	return Route{
 		HeaderName: "SOAPAction",
+		Actions:    map[actionKey]Action{},
+		Services:   map[string]string{},
 	}
 }
 
@@ -34,34 +55,63 @@ func NewRoute() Route {
 type RoutingGroup struct {
 	Route       *Route
 	ServiceType string
+	Namespace   string
+}
+
+// serviceNamespace returns the namespace Nintendo used for the given service type,
+// such as urn:ecs.wsapi.broadon.com for ecs.
+func serviceNamespace(serviceType string) string {
+	return "urn:" + serviceType + ".wsapi.broadon.com"
 }
 
-// HandleGroup returns a routing group type for the given service type.
+// HandleGroup returns a routing group type for the given service type, within the namespace Nintendo used.
 func (r *Route) HandleGroup(serviceType string) RoutingGroup {
+	return r.HandleNamespace(serviceType, serviceNamespace(serviceType))
+}
+
+// HandleNamespace returns a routing group type for the given service type within a custom namespace,
+// permitting services beyond those of Nintendo. A namespace may only belong to a single service type.
+func (r *Route) HandleNamespace(serviceType string, namespace string) RoutingGroup {
+	if existing, ok := r.Services[namespace]; ok && existing != serviceType {
+		panic(fmt.Sprintf("namespace %s is already registered to service %s", namespace, existing))
+	}
+	r.Services[namespace] = serviceType
+
 	return RoutingGroup{
 		Route:       r,
 		ServiceType: serviceType,
+		Namespace:   namespace,
 	}
 }
 
 // Unauthenticated associates an action to a function to be handled without authentication.
-func (r *RoutingGroup) Unauthenticated(action string, function func(e *Envelope)) {
-	r.Route.Actions = append(r.Route.Actions, Action{
-		ActionName:          action,
-		Callback:            function,
-		NeedsAuthentication: false,
-		ServiceType:         r.ServiceType,
-	})
+func (r *RoutingGroup) Unauthenticated(action string, function func(e *Envelope) error) {
+	r.register(action, function, false)
 }
 
 // Authenticated associates an action to a function to be handled with authentication.
-func (r *RoutingGroup) Authenticated(action string, function func(e *Envelope)) {
-	r.Route.Actions = append(r.Route.Actions, Action{
+func (r *RoutingGroup) Authenticated(action string, function func(e *Envelope) error) {
+	r.register(action, function, true)
+}
+
+// register associates an action to a function. As with http.ServeMux,
+// registering an action twice panics, so that mistakes are caught at startup.
+func (r *RoutingGroup) register(action string, function func(e *Envelope) error, needsAuthentication bool) {
+	key := actionKey{
+		Namespace:  r.Namespace,
+		ActionName: action,
+	}
+	if _, ok := r.Route.Actions[key]; ok {
+		panic(fmt.Sprintf("action %s is already registered within service %s", action, r.ServiceType))
+	}
+
+	r.Route.Actions[key] = Action{
 		ActionName:          action,
 		Callback:            function,
-		NeedsAuthentication: true,
+		NeedsAuthentication: needsAuthentication,
 		ServiceType:         r.ServiceType,
-	})
+		Namespace:           r.Namespace,
+	}
 }
 
 func (route *Route) Handle() http.Handler {
@@ -69,85 +119,85 @@ func (route *Route) Handle() http.Handler {
 		log.Printf("%s %s via %s", aurora.Yellow(r.Method), aurora.Cyan(r.URL), aurora.Cyan(r.Host))
 
 		// Check if there's a header of the type we need.
-		service, actionName := parseAction(r.Header.Get("SOAPAction"))
-		if service == "" || actionName == "" || r.Method != "POST" {
-			printError(w, "WiiSOAP can't handle this. Try again later.")
+		namespace, actionName := parseAction(r.Header.Get(route.HeaderName))
+		if namespace == "" || actionName == "" || r.Method != "POST" {
+			writeFault(w, FaultClient, "WiiSOAP can't handle this. Try again later.")
 			return
 		}
 
 		// Verify this is a service type we know.
-		switch service {
-		case "ecs":
-		case "ias":
-		case "cas":
-			break
-		default:
-			printError(w, "Unsupported service type...")
+		service, ok := route.Services[namespace]
+		if !ok {
+			writeFault(w, FaultClient, "Unsupported service type...")
 			return
 		}
 
 		debugPrint("[!] Incoming ", aurora.Yellow(strings.ToUpper(service)), " request - handling request ", aurora.Yellow(actionName))
 		body, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			printError(w, "Error reading request body...")
+			writeFault(w, FaultServer, "Error reading request body...")
 			return
 		}
 
 		// Ensure we can route to this action before processing.
-		// Search all registered actions and find a matching action.
-		var action Action
-		for _, routeAction := range route.Actions {
-			if routeAction.ActionName == actionName && routeAction.ServiceType == service {
-				action = routeAction
-			}
-		}
-
-		// Action is only properly populated if we found it previously.
-		if action.ActionName == "" && action.ServiceType == "" {
-			printError(w, "WiiSOAP can't handle this. Try again later.")
+		action, ok := route.Actions[actionKey{
+			Namespace:  namespace,
+			ActionName: actionName,
+		}]
+		if !ok {
+			writeFault(w, FaultClient, "Unknown action "+actionName)
 			return
 		}
 
 		debugPrint("Client sent:\n", aurora.BrightGreen(string(body)))
 
 		// Insert the current action being performed.
-		e, err := NewEnvelope(service, actionName, body)
+		e, err := NewEnvelope(namespace, actionName, body)
 		if err != nil {
-			printError(w, "Error interpreting request body: "+err.Error())
+			writeFault(w, FaultClient, "Error interpreting request body: "+err.Error())
 			return
 		}
 
-		// Check for authentication.
+		// Check for authentication, calling this action only if successful.
+		// The EC library expects a response with an ErrorCode should authentication fail.
 		if action.NeedsAuthentication {
-			success, err := checkAuthentication(e)
-			// Catch-all in case of invalid formatting or true invalidity.
-			if !success || (err != nil) {
-				http.Error(w, "Unauthorized.", http.StatusUnauthorized)
-				return
-			}
+			err = authenticate(e)
+		}
+		if err == nil {
+			err = action.Callback(e)
 		}
 
-		// Call this action.
-		action.Callback(e)
+		// An error ends the action, and nothing it added to its response is sent.
+		if err != nil {
+			e.Fail(err)
+		}
 
 		// The action has now finished its task, and we can serialize.
-		// Output may or may not truly be XML depending on where things failed.
-		// We'll expect the best, however.
-		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
-		success, contents := e.becomeXML()
-		if !success {
-			// This is not what we wanted, and we need to reflect that.
-			w.WriteHeader(http.StatusInternalServerError)
+		// Failures are described by the ErrorCode within the response, which consoles expect to be sent successfully.
+		contents, err := e.becomeXML()
+		if err != nil {
+			writeFault(w, FaultServer, "An error occurred marshalling XML: "+err.Error())
+			return
 		}
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
 		w.Write([]byte(contents))
 		debugPrint("Writing response:\n", aurora.BrightCyan(contents))
 	})
 }
 
-const (
-	RouteVerifyHashedStatement   = `SELECT 1 FROM userbase WHERE device_token_hashed=$1 AND account_id=$2 AND device_id=$3`
-	RouteVerifyUnhashedStatement = `SELECT 1 FROM userbase WHERE device_token=$1 AND account_id=$2 AND device_id=$3`
-)
+// authenticate describes why a request requiring authentication is not permitted, if it is not.
+func authenticate(e *Envelope) error {
+	success, err := checkAuthentication(e)
+	// Catch-all in case of invalid formatting or true invalidity.
+	if err == nil && !success {
+		err = errors.New("invalid device token")
+	}
+	if err != nil {
+		return actionError(ErrorCodeAuthenticationFailed, "authentication failed", err)
+	}
+
+	return nil
+}
 
 // checkAuthentication validates various factors from a given request requiring authentication.
 func checkAuthentication(e *Envelope) (bool, error) {
@@ -156,10 +206,7 @@ func checkAuthentication(e *Envelope) (bool, error) {
 	}
 
 	// Get necessary authentication identifiers.
-	deviceToken, err := e.getKey("DeviceToken")
-	if err != nil {
-		return false, err
-	}
+	deviceToken := e.DeviceToken()
 	accountId, err := e.AccountId()
 	if err != nil {
 		return false, err
@@ -170,27 +217,15 @@ func checkAuthentication(e *Envelope) (bool, error) {
 		return false, nil
 	}
 
-	var statement string
-	if tokenType == TokenTypeHashed {
-		statement = RouteVerifyHashedStatement
-	} else if tokenType == TokenTypeUnhashed {
-		statement = RouteVerifyUnhashedStatement
-	}
-
 	// Check using various input given.
-	row := pool.QueryRow(ctx, statement, hash, accountId, e.DeviceId())
-
-	var throwaway int
-	err = row.Scan(&throwaway)
-	if err == pgx.ErrNoRows {
-		return false, err
-	} else if err != nil {
+	valid, err := store.VerifyToken(accountId, e.DeviceId(), hash, tokenType)
+	if err != nil {
 		// We shouldn't encounter other errors.
-		debugPrint("error occurred while checking authentication: %v\n", err)
+		debugPrint("error occurred while checking authentication: ", err)
 		return false, err
-	} else {
-		return true, nil
 	}
+
+	return valid, nil
 }
 
 // validateTokenFormat confirms the prefix, size and type of tokens,
@@ -219,7 +254,25 @@ func determineTokenFormat(token string) (string, TokenType) {
 	return "", TokenTypeInvalid
 }
 
-func printError(w http.ResponseWriter, reason string) {
-	http.Error(w, reason, http.StatusInternalServerError)
+// writeFault responds with a SOAP Fault for requests we cannot interpret as any action.
+// As SOAP 1.1 requires, faults are sent with a 500 status.
+func writeFault(w http.ResponseWriter, faultCode string, reason string) {
+	contents, err := xml.Marshal(FaultEnvelope{
+		SOAPEnv: "http://schemas.xmlsoap.org/soap/envelope/",
+		Body: FaultBody{
+			Fault: Fault{
+				FaultCode:   faultCode,
+				FaultString: reason,
+			},
+		},
+	})
+	if err != nil {
+		http.Error(w, reason, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte(xml.Header + string(contents)))
 	debugPrint("Failed to handle request: ", aurora.Red(reason))
 }
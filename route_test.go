@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDuplicateActionRejected(t *testing.T) {
+	r := NewRoute()
+	ecs := r.HandleGroup("ecs")
+	ecs.Authenticated("CheckDeviceStatus", checkDeviceStatus)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering an action twice did not panic")
+		}
+	}()
+	ecs.Authenticated("CheckDeviceStatus", checkAccountBalance)
+}
+
+func TestNamespaceRejectedForOtherService(t *testing.T) {
+	r := NewRoute()
+	r.HandleNamespace("wiimart", "urn:wiimart.example")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a namespace to a second service did not panic")
+		}
+	}()
+	r.HandleNamespace("other", "urn:wiimart.example")
+}
+
+func TestParseAction(t *testing.T) {
+	for header, expected := range map[string][2]string{
+		"urn:ecs.wsapi.broadon.com/CheckDeviceStatus":   {"urn:ecs.wsapi.broadon.com", "CheckDeviceStatus"},
+		`"urn:ias.wsapi.broadon.com/GetChallenge"`:      {"urn:ias.wsapi.broadon.com", "GetChallenge"},
+		"urn:wiimart.example/services/ListAnnouncement": {"urn:wiimart.example/services", "ListAnnouncement"},
+		"CheckDeviceStatus":                             {"", ""},
+	} {
+		namespace, action := parseAction(header)
+		if namespace != expected[0] || action != expected[1] {
+			t.Errorf("parseAction(%q) = %q, %q; expected %q, %q", header, namespace, action, expected[0], expected[1])
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+)
+
+// Store describes all persistent state WiiSOAP operates on.
+// Operations which modify several kinds of state, such as purchases, happen together or not at all.
+type Store interface {
+	// CreateUser registers a new account. ErrUserExists is returned if the device or account is already registered.
+	CreateUser(user User) error
+	// QueryUser returns the account registered to the given device, or nil if there is none.
+	QueryUser(region string, deviceId int) (*User, error)
+	// IsRegistered determines whether the given device is registered with the given serial number.
+	IsRegistered(deviceId int, serialNumber string, region string) (bool, error)
+	// VerifyToken determines whether the given device token belongs to the given account and device.
+	VerifyToken(accountId int64, deviceId int, token string, tokenType TokenType) (bool, error)
+	// RemoveUser removes the given account alongside its owned titles and tickets, optionally archiving them.
+	// Points and transactions are retained. ErrUnknownAccount is returned if the account is not registered to this device.
+	RemoveUser(accountId int64, deviceId int, archive bool) error
+	// MoveUser transfers the given account, its tickets and the gifts pending for its device, to the device
+	// the target account is registered to, replacing the target account. ErrDeviceExists is returned if the
+	// target account has owned titles or transactions. ErrMigrateLimitReached is returned if either the account
+	// or any of its tickets has been moved as often as permitted.
+	MoveUser(accountId int64, deviceId int, target User) error
+
+	// OwnedTitles returns all titles owned by the given account.
+	OwnedTitles(accountId int64) ([]ownedTitle, error)
+	// OwnedContents returns all content indexes within the given title purchased by the given account.
+	OwnedContents(accountId int64, titleId string) ([]int, error)
+	// Subscriptions returns all subscriptions to the given title held by the given account, including those expired.
+	Subscriptions(accountId int64, titleId string) ([]subscription, error)
+	// Tickets returns all tickets issued to the given account.
+	Tickets(accountId int64) ([]storedTicket, error)
+	// IssueTicket returns the ticket previously issued for the granted title,
+	// or stores and returns the granted ticket if none was.
+	IssueTicket(accountId int64, grant ticketGrant) ([]byte, error)
+
+	// Balance returns the current points balance for the given account.
+	Balance(accountId int64) (int, error)
+	// Transactions returns a page of transactions for the given account, newest first,
+	// alongside the total amount available. If titleId is non-empty, only its transactions are returned.
+	// Transactions for expired subscriptions are omitted.
+	Transactions(accountId int64, titleId string, offset int, size int) ([]transactionRecord, int, error)
+	// PurchaseTitle records the given purchase, charging its total and granting its title and ticket.
+	// If the title is a subscription, the renewed subscription is recorded as well.
+	// It returns the resulting balance and the ticket issued.
+	PurchaseTitle(accountId int64, record *transactionRecord, version int, grant ticketGrant, renewed *subscription) (int, []byte, error)
+	// PurchasePoints records the given purchase, crediting the given amount of points.
+	PurchasePoints(accountId int64, record *transactionRecord, points int) (int, error)
+	// GiftTitle records the given gift of the record's item, charging its total and holding it for the recipient.
+	// It returns the resulting balance and the transaction ID reserved for the recipient.
+	GiftTitle(accountId int64, record *transactionRecord, senderFriendCode string, recipientFriendCode string) (int, int64, error)
+	// AcceptGift grants the gifted title at the given version, and its ticket, to the given account.
+	// ErrGiftNotFound is returned if there is no such gift, or if it was addressed to another console.
+	AcceptGift(accountId int64, transactionId int64, version int, grant ticketGrant) (*gift, []byte, error)
+
+	// CatalogItems returns a page of items matching the given filter, alongside the total amount matching.
+	CatalogItems(filter catalogFilter) ([]catalogItem, int, error)
+	// CatalogItem returns the given item alongside all of its prices, including any limits on their licences,
+	// or nil if it is not within our catalog.
+	CatalogItem(itemId int) (*catalogItem, error)
+	// CatalogTitles returns a page of titles within the given category and platform, alongside the total amount matching.
+	CatalogTitles(category string, platform string, offset int, size int) ([]TitleInfo, int, error)
+	// CatalogTitle returns details about the given title, or nil if it is not within our catalog.
+	CatalogTitle(titleId string) (*TitleInfo, error)
+	// Categories returns all categories within our catalog.
+	Categories() ([]Category, error)
+	// ContentSets returns all content sets available for the given title.
+	ContentSets(titleId string) ([]ContentSet, error)
+	// SubscriptionPlan returns the subscription the given service title declares, or nil if it does not.
+	SubscriptionPlan(titleId string) (*subscriptionPlan, error)
+}
+
+var (
+	ErrUserExists          = errors.New("user already exists")
+	ErrDeviceExists        = errors.New("device is already registered")
+	ErrMigrateLimitReached = errors.New("account or ticket migration limit reached")
+	ErrGiftNotFound        = errors.New("gift does not exist")
+)
+
+// StorageKind determines which Store implementation is used.
+type StorageKind string
+
+const (
+	// StoragePostgres persists state within PostgreSQL.
+	StoragePostgres StorageKind = "postgres"
+	// StorageMemory retains state in memory only, and is lost upon exit.
+	StorageMemory StorageKind = "memory"
+)
+
+// store is the Store used by all handlers.
+var store Store
+
+// User describes a registered account.
+type User struct {
+	AccountId         int64
+	DeviceId          int
+	DeviceToken       string
+	DeviceTokenHashed string
+	Region            string
+	SerialNumber      string
+	OriginalTitle     string
+	// DeviceCode is the friend code the console registered with.
+	DeviceCode string
+}
+
+// ownedTitle describes a title owned by an account.
+type ownedTitle struct {
+	TitleId string
+	Version int
+}
+
+// ticketGrant describes a ticket to be issued for a title.
+type ticketGrant struct {
+	TitleId  string
+	TicketId uint64
+	Ticket   []byte
+	// Replace determines whether this ticket supersedes any previously issued for the title.
+	Replace bool
+	// Contents lists the content indexes this ticket permits access to, or nil if it permits the whole title.
+	// Purchasing the ticket records these contents as owned.
+	Contents []int
+}
+
+// gift describes a title gifted to another account.
+type gift struct {
+	TitleId          string
+	ItemId           int
+	SenderFriendCode string
+}
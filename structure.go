@@ -43,6 +43,31 @@ type Config struct {
 	Debug     bool `xml:"Debug"`
 	NoAuth    bool `xml:"NoAuth"`
 	Whitelist bool `xml:"Whitelist"`
+
+	Storage          StorageKind      `xml:"Storage"`
+	MemoryCatalog    string           `xml:"MemoryCatalog"`
+	UnregisterPolicy UnregisterPolicy `xml:"UnregisterPolicy"`
+	MigrateLimit     int              `xml:"MigrateLimit"`
+
+	SystemTitles []SystemTitle `xml:"SystemTitles>Title"`
+	ContentRoot  string        `xml:"ContentRoot"`
+
+	TitleProvider TitleProviderKind `xml:"TitleProvider"`
+	TitleSource   string            `xml:"TitleSource"`
+	TitleCacheTTL string            `xml:"TitleCacheTTL"`
+
+	CatalogSource       string `xml:"CatalogSource"`
+	CatalogSyncInterval string `xml:"CatalogSyncInterval"`
+}
+
+// SystemTitle describes a system title offered via NUS, such as the System Menu or an IOS.
+type SystemTitle struct {
+	// Region limits this title to consoles of the given region, such as USA.
+	// It is offered to consoles of all regions if empty.
+	Region  string `xml:"Region,attr"`
+	TitleId string `xml:"TitleId"`
+	Version int    `xml:"Version"`
+	FsSize  int64  `xml:"FsSize"`
 }
 
 // Envelope represents the root element of any response, soapenv:Envelope.
@@ -58,10 +83,8 @@ type Envelope struct {
 	// Used for internal state tracking.
 	doc *xmlquery.Node
 
-	// Common IAS values.
-	region   string
-	country  string
-	language string
+	// Common values sent with every request.
+	common commonRequest
 }
 
 // Body represents the nested soapenv:Body element as a child on the root element,
@@ -80,16 +103,39 @@ type Response struct {
 
 	// These common fields are persistent across all requests.
 	Version            string `xml:"Version"`
-	DeviceId           int `xml:"DeviceId"`
+	DeviceId           int    `xml:"DeviceId"`
 	MessageId          string `xml:"MessageId"`
 	TimeStamp          string `xml:"TimeStamp"`
-	ErrorCode          int
+	ErrorCode          ErrorCode
 	ServiceStandbyMode bool `xml:"ServiceStandbyMode"`
 
 	// Allows for <name>[dynamic content]</name> situations.
 	CustomFields []interface{}
 }
 
+// FaultEnvelope represents a response to a request we could not interpret as any action,
+// containing a soapenv:Fault in place of a response.
+type FaultEnvelope struct {
+	XMLName string `xml:"soapenv:Envelope"`
+	SOAPEnv string `xml:"xmlns:soapenv,attr"`
+
+	Body FaultBody
+}
+
+// FaultBody represents the soapenv:Body element of a FaultEnvelope.
+type FaultBody struct {
+	XMLName string `xml:"soapenv:Body"`
+
+	Fault Fault
+}
+
+// Fault describes why a request could not be handled, as defined by SOAP 1.1.
+type Fault struct {
+	XMLName     string `xml:"soapenv:Fault"`
+	FaultCode   string `xml:"faultcode"`
+	FaultString string `xml:"faultstring"`
+}
+
 // KVField represents an individual node in form of <XMLName>Contents</XMLName>.
 type KVField struct {
 	XMLName xml.Name
@@ -104,10 +150,11 @@ type Balance struct {
 }
 
 // Limits represents a common XML structure for transaction information.
+// Limits holds the value of the limit, interpreted according to its kind.
 type Limits struct {
-	XMLName   xml.Name   `xml:"Limits"`
-	Limits    LimitKinds `xml:"Limits"`
-	LimitKind string     `xml:"LimitKind"`
+	XMLName   xml.Name `xml:"Limits"`
+	Limits    int      `xml:"Limits"`
+	LimitKind string   `xml:"LimitKind"`
 }
 
 // Transactions represents a common XML structure.
@@ -152,10 +199,11 @@ type Attributes struct {
 }
 
 // ContentsMetadata describes data about contents within a title.
+// ContentIndex is omitted when the entry describes the whole title.
 type ContentsMetadata struct {
 	XMLName       xml.Name `xml:"Contents"`
 	TitleIncluded bool     `xml:"TitleIncluded"`
-	ContentIndex  int      `xml:"ContentIndex"`
+	ContentIndex  *int     `xml:"ContentIndex,omitempty"`
 }
 
 // Price holds the price for a title.
@@ -175,21 +223,66 @@ type Prices struct {
 
 // Items allows specifying an overview of a title's contents.
 type Items struct {
-	XMLName    xml.Name         `xml:"Items"`
-	TitleId    string           `xml:"TitleId"`
-	Contents   ContentsMetadata `xml:"Contents"`
-	Attributes []Attributes     `xml:"Attribute,omitempty"`
-	Ratings    Ratings          `xml:"Ratings,omitempty"`
-	Prices     Prices           `xml:"Prices,omitempty"`
+	XMLName    xml.Name           `xml:"Items"`
+	TitleId    string             `xml:"TitleId"`
+	Contents   []ContentsMetadata `xml:"Contents"`
+	Attributes []Attributes       `xml:"Attribute,omitempty"`
+	Ratings    []Ratings          `xml:"Ratings,omitempty"`
+	Prices     []Prices           `xml:"Prices,omitempty"`
 }
 
 // Ratings allows specifying the rating of an item across multiple properties.
 type Ratings struct {
-	XMLName xml.Name `xml:"Ratings"`
-	Name    string   `xml:"Name"`
-	Rating  int      `xml:"Rating"`
-	Age     int      `xml:"Age"`
-	// There is also a `Descriptors` field
+	XMLName     xml.Name `xml:"Ratings"`
+	Name        string   `xml:"Name"`
+	Rating      int      `xml:"Rating"`
+	Age         int      `xml:"Age"`
+	Descriptors []string `xml:"Descriptors,omitempty"`
+}
+
+// TitleInfo describes a title within our catalog.
+type TitleInfo struct {
+	XMLName      xml.Name      `xml:"TitleInfo"`
+	TitleId      string        `xml:"TitleId"`
+	TitleName    string        `xml:"TitleName"`
+	Platform     string        `xml:"Platform"`
+	Publisher    string        `xml:"Publisher,omitempty"`
+	TitleVersion int           `xml:"TitleVersion"`
+	TitleSize    int64         `xml:"TitleSize,omitempty"`
+	Ratings      []Ratings     `xml:"Ratings,omitempty"`
+	Categories   []Category    `xml:"Categories,omitempty"`
+	Contents     []ContentInfo `xml:"Contents,omitempty"`
+}
+
+// TitleVersion describes a system title available for update within GetSystemUpdate.
+type TitleVersion struct {
+	XMLName xml.Name `xml:"TitleVersion"`
+	TitleId string   `xml:"TitleId"`
+	Version int      `xml:"Version"`
+	FsSize  int64    `xml:"FsSize,omitempty"`
+}
+
+// ContentInfo describes an individual content within a title.
+type ContentInfo struct {
+	XMLName      xml.Name `xml:"Contents"`
+	ContentIndex int      `xml:"ContentIndex"`
+	ContentId    string   `xml:"ContentId"`
+	ContentSize  int64    `xml:"ContentSize"`
+}
+
+// ContentSet describes a group of contents within a title that may be purchased together.
+type ContentSet struct {
+	XMLName        xml.Name `xml:"ContentSets"`
+	ContentSetId   int      `xml:"ContentSetId"`
+	Name           string   `xml:"Name"`
+	ContentIndexes []int    `xml:"ContentIndex"`
+}
+
+// Category describes a category titles may be listed within.
+type Category struct {
+	XMLName      xml.Name `xml:"Categories"`
+	CategoryCode string   `xml:"CategoryCode"`
+	CategoryName string   `xml:"CategoryName"`
 }
 
 type Sender struct {
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"time"
+
+	v1Ticket "github.com/OpenShopChannel/V1TicketGenerator"
+	"github.com/jackc/pgx/v4"
+	"github.com/wii-tools/wadlib"
+)
+
+const (
+	QuerySubscriptionPlanStatement = `SELECT duration_days, renewal
+		FROM catalog_subscriptions
+		WHERE title_id = $1`
+
+	QuerySubscriptionsStatement = `SELECT item_id, title_id, COALESCE(reference_id, ''), date_expires
+		FROM subscriptions
+		WHERE account_id = $1 AND title_id = $2
+		ORDER BY item_id`
+
+	StoreSubscriptionStatement = `INSERT INTO subscriptions (account_id, item_id, title_id, reference_id, date_expires)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (account_id, item_id) DO UPDATE
+		SET reference_id = EXCLUDED.reference_id, date_expires = EXCLUDED.date_expires`
+)
+
+// SubscriptionRenewal determines how purchasing an active subscription again affects its expiry.
+type SubscriptionRenewal string
+
+const (
+	// RenewExtend adds the subscription's duration to its current expiry.
+	RenewExtend SubscriptionRenewal = "extend"
+	// RenewReset begins the subscription anew from the time of purchase.
+	RenewReset SubscriptionRenewal = "reset"
+)
+
+// subscriptionPlan describes the subscription a service title declares.
+type subscriptionPlan struct {
+	DurationDays int
+	Renewal      SubscriptionRenewal
+}
+
+// subscription describes a subscription purchased by an account, such as a Wii no Ma theatre.
+type subscription struct {
+	ItemId      int
+	TitleId     string
+	ReferenceId string
+	DateExpires time.Time
+}
+
+// Active determines whether this subscription has yet to expire.
+func (s subscription) Active(now time.Time) bool {
+	return s.DateExpires.After(now)
+}
+
+// renew returns the subscription resulting from purchasing the given item at the given time,
+// taking into account any subscription currently held for it.
+func (p subscriptionPlan) renew(current *subscription, itemId int, titleId string, referenceId string, now time.Time) subscription {
+	start := now
+	if p.Renewal == RenewExtend && current != nil && current.Active(now) {
+		start = current.DateExpires
+	}
+
+	return subscription{
+		ItemId:      itemId,
+		TitleId:     titleId,
+		ReferenceId: referenceId,
+		DateExpires: start.AddDate(0, 0, p.DurationDays).UTC(),
+	}
+}
+
+// subscriptionTicket returns the given ticket in the v1 format, listing all active subscriptions.
+// The ticket must be for the title the subscriptions were purchased for.
+func subscriptionTicket(ticket *wadlib.Ticket, subscriptions []subscription, now time.Time) ([]byte, error) {
+	ticket.FileVersion = 1
+	ticket.AccessTitleMask = math.MaxUint32
+	ticket.LicenseType = 5
+
+	baseTicket, err := encodeTicket(ticket)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []v1Ticket.V1SubscriptionRecord
+	for _, current := range subscriptions {
+		if !current.Active(now) {
+			continue
+		}
+
+		referenceId, err := hex.DecodeString(current.ReferenceId)
+		if err != nil {
+			return nil, fmt.Errorf("subscription for item %d has an invalid reference ID: %w", current.ItemId, err)
+		}
+
+		record := v1Ticket.V1SubscriptionRecord{
+			ExpirationTime: uint32(current.DateExpires.Unix()),
+		}
+		copy(record.ReferenceID[:], referenceId)
+		records = append(records, record)
+	}
+
+	return v1Ticket.CreateV1Ticket(baseTicket, records)
+}
+
+// querySubscriptionPlan returns the subscription the given title declares, or nil if it does not.
+func querySubscriptionPlan(q querier, titleId string) (*subscriptionPlan, error) {
+	var plan subscriptionPlan
+	err := q.QueryRow(ctx, QuerySubscriptionPlanStatement, titleId).Scan(&plan.DurationDays, &plan.Renewal)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &plan, nil
+}
+
+// querySubscriptions returns all subscriptions to the given title held by the given account, including those expired.
+func querySubscriptions(q querier, accountId int64, titleId string) ([]subscription, error) {
+	rows, err := q.Query(ctx, QuerySubscriptionsStatement, accountId, titleId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []subscription
+	for rows.Next() {
+		var current subscription
+		err = rows.Scan(&current.ItemId, &current.TitleId, &current.ReferenceId, &current.DateExpires)
+		if err != nil {
+			return nil, err
+		}
+
+		subscriptions = append(subscriptions, current)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+// storeSubscription records the given subscription for an account, replacing any previously held for its item.
+func storeSubscription(q querier, accountId int64, current subscription) error {
+	_, err := q.Exec(ctx, StoreSubscriptionStatement, accountId, current.ItemId, current.TitleId,
+		nullString(current.ReferenceId), current.DateExpires)
+	return err
+}
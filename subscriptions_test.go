@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSubscriptionRenewal(t *testing.T) {
+	now := time.Date(2023, time.March, 14, 12, 0, 0, 0, time.UTC)
+	current := &subscription{ItemId: 3, DateExpires: now.AddDate(0, 0, 10)}
+	expired := &subscription{ItemId: 3, DateExpires: now.AddDate(0, 0, -1)}
+
+	tests := []struct {
+		name     string
+		plan     subscriptionPlan
+		current  *subscription
+		expected time.Time
+	}{
+		{"new", subscriptionPlan{DurationDays: 30, Renewal: RenewExtend}, nil, now.AddDate(0, 0, 30)},
+		{"extend", subscriptionPlan{DurationDays: 30, Renewal: RenewExtend}, current, now.AddDate(0, 0, 40)},
+		{"extend expired", subscriptionPlan{DurationDays: 30, Renewal: RenewExtend}, expired, now.AddDate(0, 0, 30)},
+		{"reset", subscriptionPlan{DurationDays: 30, Renewal: RenewReset}, current, now.AddDate(0, 0, 30)},
+	}
+
+	for _, test := range tests {
+		renewed := test.plan.renew(test.current, 3, WiinoMaServiceTitleID, testWiinoMaRefId, now)
+		if !renewed.DateExpires.Equal(test.expected) {
+			t.Errorf("%s: expires %s, expected %s", test.name, renewed.DateExpires, test.expected)
+		}
+	}
+}
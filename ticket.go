@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/wii-tools/wadlib"
+)
+
+const (
+	QueryTicketStatement = `SELECT ticket FROM tickets
+		WHERE account_id = $1 AND title_id = $2`
+
+	StoreTicketStatement = `INSERT INTO tickets (account_id, title_id, ticket_id, ticket, date_issued, migrate_limit)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (account_id, title_id) DO UPDATE
+		SET ticket_id = EXCLUDED.ticket_id, ticket = EXCLUDED.ticket, date_issued = EXCLUDED.date_issued`
+
+	QueryStoredTicketsStatement = `SELECT title_id, ticket, migrate_count, migrate_limit
+		FROM tickets
+		WHERE account_id = $1
+		ORDER BY title_id`
+
+	MigrateTicketStatement = `UPDATE tickets
+		SET ticket = $3, date_issued = $4, migrate_count = migrate_count + 1
+		WHERE account_id = $1 AND title_id = $2`
+)
+
+// defaultMigrateLimit is the amount of times a ticket may be migrated to another console
+// when no limit has been configured.
+const defaultMigrateLimit = 3
+
+// ticketAccountIdOffset is the offset of our account ID within the ticket's custom data,
+// relative to wadlib.Ticket's Unknown field.
+const ticketAccountIdOffset = 2
+
+// querier describes the subset of pgxpool.Pool and pgx.Tx used by postgresStore,
+// permitting queries to be made within an existing transaction.
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// storedTicket describes a ticket previously issued to an account.
+type storedTicket struct {
+	TitleId      string
+	Ticket       []byte
+	MigrateCount int
+	MigrateLimit int
+}
+
+// ticketIdFor returns a stable ticket ID for the given account and title.
+// The same account will always receive the same ticket ID for a title,
+// permitting consoles to restore their tickets after a reinstall.
+func ticketIdFor(accountId int64, titleId string) (uint64, error) {
+	intTitleId, err := strconv.ParseUint(titleId, 16, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	var source [16]byte
+	binary.BigEndian.PutUint64(source[0:8], uint64(accountId))
+	binary.BigEndian.PutUint64(source[8:16], intTitleId)
+	hash := sha1.Sum(source[:])
+
+	// Nintendo's ticket IDs begin with 0x0001, and we mirror this.
+	return 0x0001000000000000 | binary.BigEndian.Uint64(hash[:8])&0x0000FFFFFFFFFFFF, nil
+}
+
+// formatTicketId returns the ticket ID in the format consoles expect, such as 0001F72A2763BFA2.
+func formatTicketId(ticketId uint64) string {
+	return fmt.Sprintf("%016X", ticketId)
+}
+
+// newTicket formulates a ticket from our template for the given account, console and title.
+func newTicket(accountId int64, deviceId int, titleId string, version int) (*wadlib.Ticket, error) {
+	var ticket wadlib.Ticket
+	err := binary.Read(bytes.NewReader(wadlib.TicketTemplate), binary.BigEndian, &ticket)
+	if err != nil {
+		return nil, err
+	}
+
+	intTitleId, err := strconv.ParseUint(titleId, 16, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	ticketId, err := ticketIdFor(accountId, titleId)
+	if err != nil {
+		return nil, err
+	}
+
+	ticket.TitleID = intTitleId
+	ticket.TicketID = ticketId
+	ticket.ConsoleID = uint32(deviceId)
+	ticket.TitleVersion = uint16(version)
+	binary.BigEndian.PutUint32(ticket.Unknown[ticketAccountIdOffset:], uint32(accountId))
+
+	// Title key is encrypted with the common key and current title ID.
+	ticket.UpdateTitleKey(contentAesKey)
+	return &ticket, nil
+}
+
+// newTicketGrant formulates a ticket for the given account, console and title, ready to be issued.
+func newTicketGrant(accountId int64, deviceId int, titleId string, version int) (ticketGrant, error) {
+	ticket, err := newTicket(accountId, deviceId, titleId, version)
+	if err != nil {
+		return ticketGrant{}, err
+	}
+
+	contents, err := encodeTicket(ticket)
+	if err != nil {
+		return ticketGrant{}, err
+	}
+
+	return ticketGrant{
+		TitleId:  titleId,
+		TicketId: ticket.TicketID,
+		Ticket:   contents,
+	}, nil
+}
+
+// rebindTicket returns a copy of the given ticket, issued to another console.
+func rebindTicket(contents []byte, deviceId int) ([]byte, error) {
+	var ticket wadlib.Ticket
+	err := binary.Read(bytes.NewReader(contents), binary.BigEndian, &ticket)
+	if err != nil {
+		return nil, err
+	}
+
+	ticket.ConsoleID = uint32(deviceId)
+	rebound, err := encodeTicket(&ticket)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retain anything following the ticket itself, such as v1 ticket data.
+	return append(rebound, contents[len(rebound):]...), nil
+}
+
+// encodeTicket returns the binary form of the given ticket.
+func encodeTicket(ticket *wadlib.Ticket) ([]byte, error) {
+	var buf bytes.Buffer
+	err := binary.Write(&buf, binary.BigEndian, ticket)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// queryTicket returns the stored ticket for the given account and title, or nil if none was issued.
+func queryTicket(q querier, accountId int64, titleId string) ([]byte, error) {
+	var ticket []byte
+	err := q.QueryRow(ctx, QueryTicketStatement, accountId, titleId).Scan(&ticket)
+	if err == pgx.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return ticket, nil
+}
+
+// saveTicket stores the given ticket, replacing any ticket previously issued for this account and title.
+func saveTicket(q querier, accountId int64, titleId string, ticketId uint64, ticket []byte) error {
+	_, err := q.Exec(ctx, StoreTicketStatement, accountId, titleId, int64(ticketId), ticket, time.Now().UTC(), migrateLimit)
+	return err
+}
+
+// queryStoredTickets returns all tickets issued to the given account.
+func queryStoredTickets(q querier, accountId int64) ([]storedTicket, error) {
+	rows, err := q.Query(ctx, QueryStoredTicketsStatement, accountId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tickets []storedTicket
+	for rows.Next() {
+		var ticket storedTicket
+		err = rows.Scan(&ticket.TitleId, &ticket.Ticket, &ticket.MigrateCount, &ticket.MigrateLimit)
+		if err != nil {
+			return nil, err
+		}
+
+		tickets = append(tickets, ticket)
+	}
+
+	return tickets, rows.Err()
+}
+
+// migrateTicket rebinds the given ticket to another console and stores it,
+// counting it against the ticket's migration limit.
+func migrateTicket(q querier, accountId int64, stored storedTicket, deviceId int) error {
+	contents, err := rebindTicket(stored.Ticket, deviceId)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.Exec(ctx, MigrateTicketStatement, accountId, stored.TitleId, contents, time.Now().UTC())
+	return err
+}
+
+// issueTicket returns the ticket previously issued for the granted title,
+// or stores and returns the granted ticket if none was.
+func issueTicket(q querier, accountId int64, grant ticketGrant) ([]byte, error) {
+	if !grant.Replace {
+		stored, err := queryTicket(q, accountId, grant.TitleId)
+		if err != nil {
+			return nil, err
+		} else if stored != nil {
+			return stored, nil
+		}
+	}
+
+	err := saveTicket(q, accountId, grant.TitleId, grant.TicketId, grant.Ticket)
+	if err != nil {
+		return nil, err
+	}
+
+	return grant.Ticket, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// TitleMetadata describes a title which may be purchased.
+type TitleMetadata struct {
+	TitleId string
+	Version int
+}
+
+// TitleProvider determines which titles may be purchased.
+type TitleProvider interface {
+	// Title returns metadata for the given title, or nil if it may not be purchased.
+	Title(titleId string) (*TitleMetadata, error)
+}
+
+// TitleProviderKind determines which TitleProvider is used.
+type TitleProviderKind string
+
+const (
+	// TitleProviderOSC validates titles against the Open Shop Channel API.
+	TitleProviderOSC TitleProviderKind = "osc"
+	// TitleProviderFile validates titles against a local file in the format of the Open Shop Channel API.
+	TitleProviderFile TitleProviderKind = "file"
+	// TitleProviderCatalog validates titles against our catalog.
+	TitleProviderCatalog TitleProviderKind = "catalog"
+)
+
+// titleProvider is the TitleProvider used to validate purchases.
+var titleProvider TitleProvider
+
+// newTitleProvider returns the TitleProvider described by the given configuration.
+// The source is the URL of the API for the OSC provider, and the path of the file for the file provider.
+func newTitleProvider(kind TitleProviderKind, source string, ttl string) (TitleProvider, error) {
+	switch kind {
+	case "", TitleProviderOSC:
+		if source == "" {
+			source = defaultOSCAPIUrl
+		}
+
+		duration := defaultTitleCacheTTL
+		if ttl != "" {
+			parsed, err := time.ParseDuration(ttl)
+			if err != nil {
+				return nil, fmt.Errorf("invalid TitleCacheTTL: %w", err)
+			} else if parsed <= 0 {
+				return nil, fmt.Errorf("TitleCacheTTL must be positive")
+			}
+			duration = parsed
+		}
+
+		return newOSCTitleProvider(source, duration), nil
+	case TitleProviderFile:
+		if source == "" {
+			return nil, fmt.Errorf("TitleSource must be the path of a file for the %q title provider", kind)
+		}
+		return loadFileTitleProvider(source)
+	case TitleProviderCatalog:
+		return catalogTitleProvider{}, nil
+	default:
+		return nil, fmt.Errorf("unknown TitleProvider %q, expected %q, %q or %q", kind, TitleProviderOSC, TitleProviderFile, TitleProviderCatalog)
+	}
+}
+
+// fileTitleProvider provides titles listed within a local file, in the format of the Open Shop Channel API.
+type fileTitleProvider struct {
+	path   string
+	titles map[string]TitleMetadata
+}
+
+// loadFileTitleProvider returns a TitleProvider for the titles within the given file.
+func loadFileTitleProvider(path string) (*fileTitleProvider, error) {
+	apps, err := readOSCApps(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileTitleProvider{
+		path:   path,
+		titles: oscTitles(apps),
+	}, nil
+}
+
+func (p *fileTitleProvider) Title(titleId string) (*TitleMetadata, error) {
+	title, ok := p.titles[titleId]
+	if !ok {
+		return nil, nil
+	}
+	return &title, nil
+}
+
+// String describes this provider for logging.
+func (p *fileTitleProvider) String() string {
+	return fmt.Sprintf("%d titles within %s", len(p.titles), p.path)
+}
+
+// catalogTitleProvider provides titles within our catalog, such as those added by import-wad.
+type catalogTitleProvider struct{}
+
+func (catalogTitleProvider) Title(titleId string) (*TitleMetadata, error) {
+	title, err := store.CatalogTitle(titleId)
+	if err != nil || title == nil {
+		return nil, err
+	}
+
+	return &TitleMetadata{
+		TitleId: title.TitleId,
+		Version: title.TitleVersion,
+	}, nil
+}
+
+// String describes this provider for logging.
+func (catalogTitleProvider) String() string {
+	return "our catalog"
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ReserveTransactionIdStatement = `SELECT nextval('transaction_id_seq')`
+
+	RecordTransactionStatement = `INSERT INTO transactions
+		(transaction_id, account_id, type, title_id, item_id, total_paid, currency, reference_id, date_created,
+			license_kind, limit_kind, limit_value)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+
+	QueryTransactionsStatement = `SELECT transaction_id, type, title_id, item_id, total_paid, currency, reference_id, date_created,
+			COALESCE(license_kind, ''), COALESCE(limit_kind, ''), COALESCE(limit_value, 0)
+		FROM transactions
+		WHERE account_id = $1
+		AND ($2 = '' OR title_id = $2)
+		AND NOT EXISTS (` + expiredSubscriptionFilter + `)
+		ORDER BY date_created DESC, transaction_id DESC
+		OFFSET $3 LIMIT $4`
+
+	CountTransactionsStatement = `SELECT COUNT(*) FROM transactions
+		WHERE account_id = $1
+		AND ($2 = '' OR title_id = $2)
+		AND NOT EXISTS (` + expiredSubscriptionFilter + `)`
+
+	// expiredSubscriptionFilter matches an expired subscription for the item a transaction purchased.
+	expiredSubscriptionFilter = `SELECT 1 FROM subscriptions
+		WHERE subscriptions.account_id = transactions.account_id
+		AND subscriptions.item_id = transactions.item_id
+		AND subscriptions.date_expires <= now()`
+)
+
+// TransactionType describes the kind of a recorded transaction, as reported to consoles.
+type TransactionType string
+
+const (
+	TransactionPurchaseGame   TransactionType = "PURCHGAME"
+	TransactionPurchasePoints TransactionType = "PURCHPOINTS"
+	TransactionGiftSent       TransactionType = "PGIFTGAME"
+	TransactionGiftReceived   TransactionType = "RGIFTGAME"
+)
+
+// transactionRecord describes a single row of the transactions table.
+type transactionRecord struct {
+	TransactionId int64
+	Type          TransactionType
+	TitleId       string
+	ItemId        int
+	TotalPaid     int
+	Currency      string
+	ReferenceId   string
+	Date          time.Time
+	// LicenseKind and Limits describe the licence purchased, if any.
+	LicenseKind LicenceKinds
+	Limits      Limits
+}
+
+// Pricing returns the price this transaction was made at, as reported to consoles.
+// Transactions recorded without a licence are permanent.
+func (r transactionRecord) Pricing() Prices {
+	pricing := Prices{
+		ItemId: r.ItemId,
+		Price: Price{
+			Amount:   r.TotalPaid,
+			Currency: r.Currency,
+		},
+		Limits:      r.Limits,
+		LicenseKind: r.LicenseKind,
+	}
+	if pricing.Limits.LimitKind == "" {
+		pricing.Limits = LimitStruct(PR)
+	}
+	if pricing.LicenseKind == "" {
+		pricing.LicenseKind = PERMANENT
+	}
+
+	return pricing
+}
+
+// reserveTransactionId returns a new, unique transaction ID.
+// IDs are sourced from a database sequence and are never reused, even if the transaction is rolled back.
+func reserveTransactionId(q querier) (int64, error) {
+	var transactionId int64
+	err := q.QueryRow(ctx, ReserveTransactionIdStatement).Scan(&transactionId)
+	return transactionId, err
+}
+
+// recordTransaction stores the given transaction for an account.
+// If the record does not yet have an ID, one is reserved and assigned.
+func recordTransaction(q querier, accountId int64, record *transactionRecord) error {
+	if record.TransactionId == 0 {
+		transactionId, err := reserveTransactionId(q)
+		if err != nil {
+			return err
+		}
+		record.TransactionId = transactionId
+	}
+	if record.Date.IsZero() {
+		record.Date = time.Now().UTC()
+	}
+	if record.Currency == "" {
+		record.Currency = "POINTS"
+	}
+
+	_, err := q.Exec(ctx, RecordTransactionStatement, record.TransactionId, accountId, record.Type,
+		nullString(record.TitleId), nullInt(record.ItemId), record.TotalPaid, record.Currency,
+		nullString(record.ReferenceId), record.Date, nullString(string(record.LicenseKind)),
+		nullString(record.Limits.LimitKind), nullInt(record.Limits.Limits))
+	return err
+}
+
+// queryTransactions returns a page of transactions for the given account, newest first,
+// alongside the total amount of transactions available.
+// If titleId is non-empty, only transactions for that title are returned.
+func queryTransactions(q querier, accountId int64, titleId string, offset int, size int) ([]transactionRecord, int, error) {
+	var total int
+	err := q.QueryRow(ctx, CountTransactionsStatement, accountId, titleId).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := q.Query(ctx, QueryTransactionsStatement, accountId, titleId, offset, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var records []transactionRecord
+	for rows.Next() {
+		var record transactionRecord
+		var recordTitleId, referenceId *string
+		var itemId *int
+		err = rows.Scan(&record.TransactionId, &record.Type, &recordTitleId, &itemId, &record.TotalPaid,
+			&record.Currency, &referenceId, &record.Date, &record.LicenseKind, &record.Limits.LimitKind, &record.Limits.Limits)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if recordTitleId != nil {
+			record.TitleId = *recordTitleId
+		}
+		if itemId != nil {
+			record.ItemId = *itemId
+		}
+		if referenceId != nil {
+			record.ReferenceId = *referenceId
+		}
+		records = append(records, record)
+	}
+
+	return records, total, rows.Err()
+}
+
+// formatTransactionId returns the transaction ID as presented to consoles.
+func formatTransactionId(transactionId int64) string {
+	return strconv.FormatInt(transactionId, 10)
+}
+
+// parseAmount interprets an amount sent by the console, such as "500" or "10.00", as an integer.
+func parseAmount(amount string) (int, error) {
+	value, err := strconv.Atoi(strings.ReplaceAll(amount, ".", ""))
+	if err != nil {
+		return 0, err
+	} else if value < 0 {
+		return 0, errors.New("amount must not be negative")
+	}
+
+	return value, nil
+}
+
+// nullString returns nil for empty strings, permitting them to be stored as NULL.
+func nullString(value string) interface{} {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+// nullInt returns nil for zero values, permitting them to be stored as NULL.
+func nullInt(value int) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
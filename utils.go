@@ -26,36 +26,32 @@ import (
 	"io"
 	"log"
 	"math/rand"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
 )
 
-var namespaceParse = regexp.MustCompile(`^urn:(.{3})\.wsapi\.broadon\.com/(.*)$`)
-
 // parseAction interprets contents along the lines of "urn:ecs.wsapi.broadon.com/CheckDeviceStatus",
-// where "CheckDeviceStatus" is the action to be performed.
+// returning the namespace of the service, "urn:ecs.wsapi.broadon.com", and the action to be performed, "CheckDeviceStatus".
 func parseAction(original string) (string, string) {
-	// Intended to return the original string, the service's name and the name of the action.
-	matches := namespaceParse.FindStringSubmatch(original)
-	if len(matches) != 3 {
-		// It seems like the passed action was not matched properly.
+	// SOAPAction may be quoted.
+	original = strings.Trim(strings.TrimSpace(original), `"`)
+
+	index := strings.LastIndex(original, "/")
+	if index == -1 {
 		return "", ""
 	}
 
-	service := matches[1]
-	action := matches[2]
-	return service, action
+	return original[:index], original[index+1:]
 }
 
 // NewEnvelope returns a new Envelope with proper attributes initialized.
-func NewEnvelope(service string, action string, body []byte) (*Envelope, error) {
+func NewEnvelope(namespace string, action string, body []byte) (*Envelope, error) {
 	// Get a sexy new timestamp to use.
 	timestampNano := fmt.Sprint(time.Now().UTC().UnixNano())[0:13]
 
 	// Tidy up parsed document for easier usage going forward.
-	doc, err := normalise(service, action, strings.NewReader(string(body)))
+	doc, err := normalise(namespace, action, strings.NewReader(string(body)))
 	if err != nil {
 		return nil, err
 	}
@@ -68,7 +64,7 @@ func NewEnvelope(service string, action string, body []byte) (*Envelope, error)
 		Body: Body{
 			Response: Response{
 				XMLName: xml.Name{Local: action + "Response"},
-				XMLNS:   "urn:" + service + ".wsapi.broadon.com",
+				XMLNS:   namespace,
 
 				TimeStamp: timestampNano,
 			},
@@ -97,67 +93,57 @@ func (e *Envelope) DeviceId() int {
 
 // Region returns the region for this request. It should be only used in IAS-related requests.
 func (e *Envelope) Region() string {
-	return e.region
+	return e.common.Region
 }
 
 // Country returns the region for this request. It should be only used in IAS-related requests.
 func (e *Envelope) Country() string {
-	return e.country
+	return e.common.Country
 }
 
 // Language returns the region for this request. It should be only used in IAS-related requests.
 func (e *Envelope) Language() string {
-	return e.language
+	return e.common.Language
 }
 
 // AccountId returns the account ID for this request. It should be only used in authenticated requests.
 // If for whatever reason AccountId is not present (such as in SyncRegistration),
 // it will return an error. Please design in a way so that this is not an issue.
 func (e *Envelope) AccountId() (int64, error) {
-	accountId, err := e.getKey("AccountId")
-	if err != nil {
-		return 0, nil
+	if e.common.AccountId == "" {
+		return 0, errors.New("missing mandatory key named AccountId")
 	}
 
-	return strconv.ParseInt(accountId, 10, 64)
+	return strconv.ParseInt(strings.TrimSpace(e.common.AccountId), 10, 64)
+}
+
+// DeviceToken returns the device token for this request. It is only present in authenticated requests.
+func (e *Envelope) DeviceToken() string {
+	return strings.TrimSpace(e.common.DeviceToken)
 }
 
 // ObtainCommon interprets a given node, and updates the envelope with common key values.
 func (e *Envelope) ObtainCommon() error {
-	var err error
-
-	// These fields are common across all requests.
-	e.Body.Response.Version, err = e.getKey("Version")
-	if err != nil {
-		return err
-	}
-	deviceIdString, err := e.getKey("DeviceId")
-	if err != nil {
-		return err
-	}
-	e.Body.Response.DeviceId, err = strconv.Atoi(deviceIdString)
-	if err != nil {
-		return err
-	}
-	e.Body.Response.MessageId, err = e.getKey("MessageId")
+	err := e.decode(&e.common)
 	if err != nil {
 		return err
 	}
 
-	// These are as well, but we do not need to send them back in our response.
-	e.region, err = e.getKey("Region")
-	if err != nil {
-		return err
+	if e.common.Region == "" {
+		e.common.Region = e.common.RegionId
 	}
-	e.country, err = e.getKey("Country")
-	if err != nil {
-		return err
+	if e.common.Country == "" {
+		e.common.Country = e.common.CountryCode
 	}
-	e.language, err = e.getKey("Language")
-	if err != nil {
-		return err
+	if e.common.Region == "" || e.common.Country == "" {
+		return errors.New("missing mandatory key named Region or Country")
 	}
 
+	// These fields are common across all requests.
+	e.Body.Response.Version = e.common.Version
+	e.Body.Response.DeviceId = e.common.DeviceId
+	e.Body.Response.MessageId = e.common.MessageId
+
 	return nil
 }
 
@@ -174,12 +160,8 @@ func (e *Envelope) AddCustomType(customType interface{}) {
 	e.Body.Response.CustomFields = append(e.Body.Response.CustomFields, customType)
 }
 
-// becomeXML marshals the Envelope object, returning the intended boolean state on success.
-// ..there has to be a better way to do this, TODO.
-func (e *Envelope) becomeXML() (bool, string) {
-	// Non-zero error codes indicate a failure.
-	intendedStatus := e.Body.Response.ErrorCode == 0
-
+// becomeXML marshals the Envelope object.
+func (e *Envelope) becomeXML() (string, error) {
 	var contents []byte
 	var err error
 
@@ -189,18 +171,16 @@ func (e *Envelope) becomeXML() (bool, string) {
 	} else {
 		contents, err = xml.Marshal(e)
 	}
-
 	if err != nil {
-		return false, "an error occurred marshalling XML: " + err.Error()
-	} else {
-		// Add XML header on top of existing contents.
-		result := xml.Header + string(contents)
-		return intendedStatus, result
+		return "", err
 	}
+
+	// Add XML header on top of existing contents.
+	return xml.Header + string(contents), nil
 }
 
 // Error sets the necessary keys for this SOAP response to reflect the given error.
-func (e *Envelope) Error(errorCode int, reason string, err error) {
+func (e *Envelope) Error(errorCode ErrorCode, reason string, err error) {
 	e.Body.Response.ErrorCode = errorCode
 
 	// Ensure all additional fields are empty to avoid conflict.
@@ -209,24 +189,28 @@ func (e *Envelope) Error(errorCode int, reason string, err error) {
 	e.AddKVNode("ErrorMessage", fmt.Sprintf("%s: %v", reason, err))
 }
 
-// parseNameValue parses the output of *xmlquery.Node.InnerText when it is a nested Name and Value node.
-func parseNameValue(s string) (string, string) {
-	s = strings.TrimSpace(s)
-	s = strings.Replace(s, " ", "", strings.Count(s, " ")-1)
-	decoded := strings.Split(s, " ")
+// Fail sets the necessary keys for this SOAP response to reflect the given error, as returned by an action.
+// Errors other than ActionError are unexpected, and are reported as a server-side failure.
+func (e *Envelope) Fail(err error) {
+	var actionErr *ActionError
+	if errors.As(err, &actionErr) {
+		e.Error(actionErr.Code, actionErr.Reason, actionErr.Err)
+		return
+	}
 
-	return strings.TrimSuffix(decoded[0], "\n"), decoded[1]
+	log.Printf("unexpected error handling %s: %v", e.Body.Response.XMLName.Local, err)
+	e.Error(ErrorCodeServerError, "server-side error", err)
 }
 
 // normalise parses a document, returning a document with only the request type's child nodes, stripped of prefix.
-func normalise(service string, action string, reader io.Reader) (*xmlquery.Node, error) {
+func normalise(namespace string, action string, reader io.Reader) (*xmlquery.Node, error) {
 	doc, err := xmlquery.Parse(reader)
 	if err != nil {
 		return nil, err
 	}
 
 	// Find the keys for this element named after the action.
-	result := doc.SelectElement("//" + service + ":" + action)
+	result := findElement(doc, namespace, action)
 	if result == nil {
 		return nil, errors.New("missing root node")
 	}
@@ -235,6 +219,23 @@ func normalise(service string, action string, reader io.Reader) (*xmlquery.Node,
 	return result, nil
 }
 
+// findElement returns the first element with the given namespace and name, regardless of its prefix.
+func findElement(node *xmlquery.Node, namespace string, name string) *xmlquery.Node {
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != xmlquery.ElementNode {
+			continue
+		}
+		if child.Data == name && child.NamespaceURI == namespace {
+			return child
+		}
+		if found := findElement(child, namespace, name); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
 // stripNamespace removes a prefix from nodes, changing a key from "ias:Version" to "Version".
 // It is based off of https://github.com/antchfx/xmlquery/issues/15#issuecomment-567575075.
 func stripNamespace(node *xmlquery.Node) {
@@ -245,28 +246,6 @@ func stripNamespace(node *xmlquery.Node) {
 	}
 }
 
-// getKey returns the value for a child key from a node, if documented.
-func (e *Envelope) getKey(key string) (string, error) {
-	node := xmlquery.FindOne(e.doc, "//"+key)
-
-	if node == nil {
-		return "", errors.New("missing mandatory key named " + key)
-	} else {
-		return node.InnerText(), nil
-	}
-}
-
-// getKeys returns a list of xmlquery.Node, if documented.
-func (e *Envelope) getKeys(key string) ([]*xmlquery.Node, error) {
-	node := xmlquery.Find(e.doc, "//"+key)
-
-	if node == nil {
-		return nil, errors.New("missing mandatory key named " + key)
-	} else {
-		return node, nil
-	}
-}
-
 // Derived from https://stackoverflow.com/a/31832326, adding numbers
 const letterBytes = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/wii-tools/wadlib"
+)
+
+const (
+	QueryServiceItemStatement = `SELECT item_id FROM service_titles
+		WHERE title_id = $1
+		ORDER BY item_id
+		LIMIT 1`
+
+	// Item IDs are allocated from a sequence, such that they are never reused.
+	ReserveServiceItemStatement = `SELECT nextval('service_item_id_seq')`
+
+	InsertServiceTitleStatement = `INSERT INTO service_titles (item_id, title_id, reference_id) VALUES ($1, $2, $3)`
+
+	ReplaceCatalogPriceStatement = `DELETE FROM catalog_prices WHERE item_id = $1 AND price_code = $2`
+
+	InsertCatalogPriceStatement = `INSERT INTO catalog_prices (item_id, price_code, amount) VALUES ($1, $2, $3)`
+
+	InsertCatalogTitleStatement = `INSERT INTO catalog_titles (title_id, name) VALUES ($1, $2)
+		ON CONFLICT (title_id) DO NOTHING`
+
+	RenameCatalogTitleStatement = `UPDATE catalog_titles SET name = $2 WHERE title_id = $1`
+
+	UpsertCatalogTitleVersionStatement = `INSERT INTO catalog_title_versions (title_id, version, title_size)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (title_id, version) DO UPDATE
+		SET title_size = EXCLUDED.title_size`
+
+	ClearCatalogContentsStatement = `DELETE FROM catalog_contents WHERE title_id = $1`
+
+	InsertCatalogContentStatement = `INSERT INTO catalog_contents (title_id, content_index, content_id, content_size)
+		VALUES ($1, $2, $3, $4)`
+)
+
+// importPricingCode is the pricing code prices are imported with, matching those of our existing catalog.
+const importPricingCode = 1
+
+// wadImport describes a title taken apart from a WAD, ready to be stored.
+type wadImport struct {
+	TitleId string
+	Version int
+	TMD     []byte
+	Ticket  []byte
+	// Contents are keyed by their content ID, and remain encrypted as served by a CCS.
+	Contents map[uint32][]byte
+	Records  []wadlib.ContentRecord
+}
+
+// TitleSize returns the total size of all contents within this title.
+func (w wadImport) TitleSize() int64 {
+	var size int64
+	for _, record := range w.Records {
+		size += int64(record.Size)
+	}
+	return size
+}
+
+// loadWADImport takes the given WAD apart, re-keying its contents to match the tickets we issue.
+func loadWADImport(contents []byte) (*wadImport, error) {
+	wad, err := wadlib.LoadWAD(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tickets we issue are all given the same title key,
+	// so contents must be encrypted with it to be usable.
+	err = wad.ChangeTitleKey(contentAesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	tmd, err := wad.GetTMD()
+	if err != nil {
+		return nil, err
+	}
+	ticket, err := wad.GetTicket()
+	if err != nil {
+		return nil, err
+	}
+
+	imported := &wadImport{
+		TitleId: fmt.Sprintf("%016X", wad.TMD.TitleID),
+		Version: int(wad.TMD.TitleVersion),
+		// As with Nintendo's CCS, the certificate chain follows both the TMD and ticket.
+		TMD:      append(tmd, wad.CertificateChain...),
+		Ticket:   append(ticket, wad.CertificateChain...),
+		Contents: map[uint32][]byte{},
+		Records:  wad.TMD.Contents,
+	}
+	for _, data := range wad.Data {
+		imported.Contents[data.Record.ID] = data.RawData
+	}
+
+	return imported, nil
+}
+
+// writeContents stores the TMD, ticket and contents of this title within the given content root,
+// laid out as served by contentHandler.
+func (w wadImport) writeContents(root string) error {
+	titleDir := filepath.Join(root, strings.ToLower(w.TitleId))
+	err := os.MkdirAll(titleDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	files := map[string][]byte{
+		"tmd":                            w.TMD,
+		fmt.Sprintf("tmd.%d", w.Version): w.TMD,
+		"cetk":                           w.Ticket,
+	}
+	for contentId, contents := range w.Contents {
+		files[fmt.Sprintf("%08x", contentId)] = contents
+	}
+
+	for name, contents := range files {
+		err = os.WriteFile(filepath.Join(titleDir, name), contents, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importCatalog creates or updates the catalog for this title, returning the item it may be purchased as.
+// An item is only created should the title not yet have one, in which case a price must be given.
+// A negative price leaves existing prices unchanged, and an empty name leaves an existing name unchanged.
+func (w wadImport) importCatalog(tx pgx.Tx, name string, price int) (int, error) {
+	var itemId int
+	err := tx.QueryRow(ctx, QueryServiceItemStatement, w.TitleId).Scan(&itemId)
+	if err == pgx.ErrNoRows {
+		if price < 0 {
+			return 0, errors.New("a price must be given for titles not yet within the shop")
+		}
+
+		err = tx.QueryRow(ctx, ReserveServiceItemStatement).Scan(&itemId)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(ctx, InsertServiceTitleStatement, itemId, w.TitleId, nil)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if price >= 0 {
+		_, err = tx.Exec(ctx, ReplaceCatalogPriceStatement, itemId, importPricingCode)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec(ctx, InsertCatalogPriceStatement, itemId, importPricingCode, price)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Titles are named by their game code until otherwise specified, such as HADA.
+	_, err = tx.Exec(ctx, InsertCatalogTitleStatement, w.TitleId, w.TitleId[8:])
+	if err != nil {
+		return 0, err
+	}
+	if name != "" {
+		_, err = tx.Exec(ctx, RenameCatalogTitleStatement, w.TitleId, name)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, UpsertCatalogTitleVersionStatement, w.TitleId, w.Version, w.TitleSize())
+	if err != nil {
+		return 0, err
+	}
+
+	// Contents always describe the latest version imported.
+	_, err = tx.Exec(ctx, ClearCatalogContentsStatement, w.TitleId)
+	if err != nil {
+		return 0, err
+	}
+	for _, record := range w.Records {
+		_, err = tx.Exec(ctx, InsertCatalogContentStatement, w.TitleId, int(record.Index), int64(record.ID), int64(record.Size))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return itemId, nil
+}
+
+// runImportWAD handles the import-wad subcommand, adding the title within a WAD to the shop.
+func runImportWAD(args []string, contentRoot string) {
+	flags := flag.NewFlagSet("import-wad", flag.ExitOnError)
+	name := flags.String("name", "", "name to list the title under")
+	price := flags.Int("price", -1, "price in points, required for titles not yet within the shop")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: WiiSOAP import-wad [-name name] [-price points] <file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if contentRoot == "" {
+		log.Fatalf("ContentRoot must be configured to import WADs\n")
+	}
+
+	contents, err := os.ReadFile(flags.Arg(0))
+	checkError(err)
+	imported, err := loadWADImport(contents)
+	checkError(err)
+
+	checkError(imported.writeContents(contentRoot))
+
+	tx, err := pool.Begin(ctx)
+	checkError(err)
+	defer tx.Rollback(ctx)
+
+	itemId, err := imported.importCatalog(tx, *name, *price)
+	checkError(err)
+	checkError(tx.Commit(ctx))
+
+	fmt.Printf("[i] Imported %s version %d with %d content(s) as item %d.\n", imported.TitleId, imported.Version, len(imported.Records), itemId)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wii-tools/wadlib"
+)
+
+// newTestWAD returns a WAD for the given title containing a single content, encrypted with another title key.
+func newTestWAD(t *testing.T, titleId uint64, contents []byte) []byte {
+	var wad wadlib.WAD
+	if err := wad.LoadTicket(wadlib.TicketTemplate); err != nil {
+		t.Fatal(err)
+	}
+	if err := wad.LoadTMD(wadlib.TMDTemplate); err != nil {
+		t.Fatal(err)
+	}
+	wad.CertificateChain = wadlib.CertChainTemplate
+
+	wad.Ticket.TitleID = titleId
+	wad.Ticket.UpdateTitleKey([16]byte{0x0F, 0x0E, 0x0D, 0x0C})
+	wad.TMD.TitleID = titleId
+	wad.TMD.TitleVersion = 3
+	wad.TMD.NumberOfContents = 1
+	wad.TMD.Contents = []wadlib.ContentRecord{{ID: 0x2A, Index: 0, Type: wadlib.TitleTypeNormal}}
+	wad.Data = []wadlib.WADFile{{Record: &wad.TMD.Contents[0]}}
+	if err := wad.UpdateContent(0, contents); err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := wad.GetWAD(wadlib.WADTypeCommon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestImportWADContents(t *testing.T) {
+	contents := []byte("Homebrew Browser, re-keyed for WiiSOAP")
+	imported, err := loadWADImport(newTestWAD(t, 0x0001000148414441, contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.TitleId != "0001000148414441" || imported.Version != 3 {
+		t.Errorf("imported %s version %d, expected 0001000148414441 version 3", imported.TitleId, imported.Version)
+	}
+	if imported.TitleSize() != int64(len(contents)) {
+		t.Errorf("title size %d, expected %d", imported.TitleSize(), len(contents))
+	}
+
+	root := t.TempDir()
+	if err = imported.writeContents(root); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"tmd", "tmd.3", "cetk", "0000002a"} {
+		if _, err = os.Stat(filepath.Join(root, "0001000148414441", name)); err != nil {
+			t.Errorf("expected %s to be written: %v", name, err)
+		}
+	}
+
+	// Served contents must be decryptable with the title key of tickets we issue.
+	encrypted, err := os.ReadFile(filepath.Join(root, "0001000148414441", "0000002a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := wadlib.WADFile{Record: &imported.Records[0], RawData: encrypted}
+	decrypted, err := file.DecryptData(contentAesKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, contents) {
+		t.Errorf("decrypted content %q, expected %q", decrypted, contents)
+	}
+}